@@ -9,11 +9,16 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"math"
 	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	vegeta "github.com/tsenart/vegeta/lib"
@@ -23,65 +28,125 @@ func attackCmd() command {
 	fs := flag.NewFlagSet("vegeta attack", flag.ExitOnError)
 	opts := &attackOpts{
 		headers: headers{http.Header{}},
+		asserts: assertOpts{header: headers{http.Header{}}},
 		laddr:   localAddr{&vegeta.DefaultLocalAddr},
+		rate:    vegeta.Rate{Freq: 50, Per: time.Second},
 	}
 
 	fs.StringVar(&opts.targetsf, "targets", "stdin", "Targets file")
 	fs.StringVar(&opts.outputf, "output", "stdout", "Output file")
 	fs.StringVar(&opts.bodyf, "body", "", "Requests body file")
 	fs.StringVar(&opts.certf, "cert", "", "x509 Certificate file")
+	fs.StringVar(&opts.format, "format", "http", "Targets format [http, json, har, log]")
+	fs.StringVar(&opts.base, "base", "", "Base URL of the request paths of log targets")
 	fs.BoolVar(&opts.lazy, "lazy", false, "Read targets lazily")
-	fs.DurationVar(&opts.duration, "duration", 10*time.Second, "Duration of the test")
+	fs.StringVar(&opts.selection, "select", "round-robin", "Target selection strategy [round-robin, random, weighted, shuffle]")
+	fs.BoolVar(&opts.template, "template", false, "Render targets as templates on every request")
+	fs.StringVar(&opts.varsf, "vars", "", "Template variables CSV file (implies -template)")
+	fs.DurationVar(&opts.duration, "duration", 10*time.Second, "Duration of the test [0 = forever]")
+	fs.Uint64Var(&opts.maxHits, "max-hits", 0, "Maximum number of requests [0 = unlimited]")
 	fs.DurationVar(&opts.timeout, "timeout", vegeta.DefaultTimeout, "Requests timeout")
-	fs.Uint64Var(&opts.rate, "rate", 50, "Requests per second")
-	fs.Uint64Var(&opts.workers, "workers", 0, "Number of workers")
+	fs.Var(&opts.rate, "rate", "Number of requests per time unit [N/duration, max]")
+	fs.StringVar(&opts.pacer, "pacer", "constant", "Rate profile [constant, linear, step, sine, replay]")
+	fs.Float64Var(&opts.slope, "slope", 0, "Linear pacer rate increase per second")
+	fs.Float64Var(&opts.step, "step", 0, "Step pacer rate increase per step")
+	fs.DurationVar(&opts.stepEvery, "step-every", 10*time.Second, "Step pacer step duration")
+	fs.DurationVar(&opts.sinePeriod, "sine-period", time.Minute, "Sine pacer wave period")
+	fs.Float64Var(&opts.sineAmp, "sine-amp", 0, "Sine pacer rate amplitude")
+	fs.Float64Var(&opts.speed, "speed", 1, "Replay pacer speed factor")
+	fs.Uint64Var(&opts.workers, "workers", vegeta.DefaultWorkers, "Initial number of workers")
+	fs.Uint64Var(&opts.maxWorkers, "max-workers", vegeta.DefaultMaxWorkers, "Maximum number of workers")
 	fs.IntVar(&opts.redirects, "redirects", vegeta.DefaultRedirects, "Number of redirects to follow")
 	fs.Var(&opts.headers, "header", "Request header")
 	fs.Var(&opts.laddr, "laddr", "Local IP address")
 	fs.BoolVar(&opts.keepalive, "keepalive", true, "Use persistent connections")
+	fs.BoolVar(&opts.chunked, "chunked", false, "Send request bodies with chunked transfer encoding")
+	fs.Var(&opts.capture, "capture", "Responses to capture headers and body of [none, errors, all]")
+	fs.Int64Var(&opts.maxBody, "max-body", vegeta.DefaultMaxBody, "Maximum number of bytes to capture from response bodies [-1 = no limit]")
+	fs.StringVar(&opts.asserts.status, "assert-status", "", "Successful status codes (comma separated)")
+	fs.Var(&opts.asserts.header, "assert-header", "Expected response header")
+	fs.StringVar(&opts.asserts.body, "assert-body", "", "Expected response body substring")
+	fs.StringVar(&opts.asserts.bodyRegexp, "assert-body-regex", "", "Expected response body regular expression")
+	fs.Var(&opts.asserts.json, "assert-json", "Expected response JSON value [path=value]")
+	fs.DurationVar(&opts.asserts.latency, "assert-latency", 0, "Maximum response latency")
+	fs.DurationVar(&opts.drain, "drain", 5*time.Second, "Time to wait for in-flight requests when stopped")
 
 	return command{fs, func(args []string) error {
 		fs.Parse(args)
+		// Replays last as long as their targets unless told otherwise.
+		duration := false
+		fs.Visit(func(f *flag.Flag) { duration = duration || f.Name == "duration" })
+		if opts.pacer == "replay" && !duration {
+			opts.duration = 0
+		}
 		return attack(opts)
 	}}
 }
 
 var (
-	errZeroDuration = errors.New("duration must be bigger than zero")
-	errZeroRate     = errors.New("rate must be bigger than zero")
 	errBadCert      = errors.New("bad certificate")
+	errSineAmp      = errors.New("sine amplitude must not be bigger than the rate")
+	errLazySelect   = errors.New("lazy targets can only be selected in round-robin")
+	errLazyReplay   = errors.New("lazy targets can't be replayed")
+	errReplaySelect = errors.New("replayed targets can only be selected in round-robin")
 )
 
 // attackOpts aggregates the attack function command options
 type attackOpts struct {
-	targetsf  string
-	outputf   string
-	bodyf     string
-	certf     string
-	lazy      bool
-	duration  time.Duration
-	timeout   time.Duration
-	rate      uint64
-	workers   uint64
-	redirects int
-	headers   headers
-	laddr     localAddr
-	keepalive bool
+	targetsf   string
+	outputf    string
+	bodyf      string
+	certf      string
+	format     string
+	base       string
+	lazy       bool
+	selection  string
+	template   bool
+	varsf      string
+	duration   time.Duration
+	maxHits    uint64
+	timeout    time.Duration
+	rate       vegeta.Rate
+	pacer      string
+	slope      float64
+	step       float64
+	stepEvery  time.Duration
+	sinePeriod time.Duration
+	sineAmp    float64
+	speed      float64
+	workers    uint64
+	maxWorkers uint64
+	redirects  int
+	headers    headers
+	laddr      localAddr
+	keepalive  bool
+	chunked    bool
+	drain      time.Duration
+	capture    vegeta.CaptureMode
+	maxBody    int64
+	asserts    assertOpts
+}
+
+// assertOpts aggregates the response assertion options
+type assertOpts struct {
+	status     string
+	header     headers
+	body       string
+	bodyRegexp string
+	json       jsonAsserts
+	latency    time.Duration
 }
 
 // attack validates the attack arguments, sets up the
 // required resources, launches the attack and writes the results
 func attack(opts *attackOpts) (err error) {
-	if opts.rate == 0 {
-		return errZeroRate
-	}
-
-	if opts.duration == 0 {
-		return errZeroDuration
+	cs, err := checks(&opts.asserts)
+	if err != nil {
+		return err
 	}
 
 	files := map[string]io.Reader{}
-	for _, filename := range []string{opts.targetsf, opts.bodyf, opts.certf} {
+	for _, filename := range []string{opts.targetsf, opts.certf, opts.varsf} {
 		if filename == "" {
 			continue
 		}
@@ -93,10 +158,9 @@ func attack(opts *attackOpts) (err error) {
 		files[filename] = f
 	}
 
-	var body []byte
-	if bodyf, ok := files[opts.bodyf]; ok {
-		if body, err = ioutil.ReadAll(bodyf); err != nil {
-			return fmt.Errorf("error reading %s: %s", opts.bodyf, err)
+	if opts.bodyf != "" {
+		if _, err := os.Stat(opts.bodyf); err != nil {
+			return fmt.Errorf("error opening %s: %s", opts.bodyf, err)
 		}
 	}
 
@@ -105,9 +169,63 @@ func attack(opts *attackOpts) (err error) {
 		src = files[opts.targetsf]
 		hdr = opts.headers.Header
 	)
-	if opts.lazy {
-		tr = vegeta.NewLazyTargeter(src, body, hdr)
-	} else if tr, err = vegeta.NewEagerTargeter(src, body, hdr); err != nil {
+	switch opts.format {
+	case "http":
+		tr = vegeta.NewLazyTargeter(src, nil, hdr)
+	case "json":
+		tr = vegeta.NewJSONTargeter(src, nil, hdr)
+	case "har":
+		tr = vegeta.NewHARTargeter(src, nil, hdr)
+	case "log":
+		tr = vegeta.NewAccessLogTargeter(src, opts.base, nil, hdr)
+	default:
+		return fmt.Errorf("bad targets format: %s", opts.format)
+	}
+	if opts.bodyf != "" {
+		tr = bodyFile(tr, opts.bodyf)
+	}
+	var tgts []*vegeta.Target
+	if opts.lazy && opts.selection != "round-robin" {
+		return errLazySelect
+	} else if opts.lazy && opts.pacer == "replay" {
+		return errLazyReplay
+	} else if !opts.lazy {
+		if tgts, err = vegeta.ReadAllTargets(tr); err != nil {
+			return err
+		}
+		if opts.pacer == "replay" {
+			if opts.selection != "round-robin" {
+				return errReplaySelect
+			}
+			sort.SliceStable(tgts, func(i, j int) bool {
+				return tgts[i].Offset < tgts[j].Offset
+			})
+		}
+		switch opts.selection {
+		case "round-robin":
+			tr = vegeta.NewStaticTargeter(tgts...)
+		case "random":
+			tr = vegeta.NewRandomTargeter(tgts...)
+		case "weighted":
+			tr = vegeta.NewWeightedTargeter(tgts...)
+		case "shuffle":
+			tr = vegeta.NewShuffledTargeter(tgts...)
+		default:
+			return fmt.Errorf("bad selection strategy: %s", opts.selection)
+		}
+	}
+	if varsf, ok := files[opts.varsf]; ok {
+		vars, err := vegeta.NewCSVVarSource(varsf)
+		if err != nil {
+			return fmt.Errorf("error reading %s: %s", opts.varsf, err)
+		}
+		tr = vegeta.NewTemplateTargeter(tr, vars)
+	} else if opts.template {
+		tr = vegeta.NewTemplateTargeter(tr, nil)
+	}
+
+	p, err := pacer(opts, tgts)
+	if err != nil {
 		return err
 	}
 
@@ -136,30 +254,124 @@ func attack(opts *attackOpts) (err error) {
 		vegeta.LocalAddr(*opts.laddr.IPAddr),
 		vegeta.TLSConfig(&tlsc),
 		vegeta.Workers(opts.workers),
+		vegeta.MaxWorkers(opts.maxWorkers),
 		vegeta.KeepAlive(opts.keepalive),
+		vegeta.Chunked(opts.chunked),
+		vegeta.MaxHits(opts.maxHits),
+		vegeta.Capture(opts.capture),
+		vegeta.MaxBody(opts.maxBody),
+		vegeta.Checks(cs...),
 	)
 
-	res := atk.Attack(tr, opts.rate, opts.duration)
+	res := atk.Attack(tr, p, opts.duration)
 	enc := gob.NewEncoder(out)
 	sig := make(chan os.Signal, 1)
-	signal.Notify(sig, os.Interrupt)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
 
+	// The first signal drains in-flight requests, a second one cancels them.
+	// Either way, all results are written before returning.
+	drain := opts.drain
 	for {
 		select {
 		case <-sig:
-			atk.Stop()
-			return nil
+			atk.Stop(drain)
+			drain = 0
 		case r, ok := <-res:
 			if !ok {
 				return nil
 			}
 			if err = enc.Encode(r); err != nil {
+				atk.Stop(0)
+				for _ = range res {
+				}
 				return err
 			}
 		}
 	}
 }
 
+// bodyFile returns a vegeta.Targeter which sets the given body file on the
+// targets returned by tr that have no body of their own, so that it's streamed
+// from disk. The targets are modified in place, so tr must return new ones
+// on every invocation.
+func bodyFile(tr vegeta.Targeter, name string) vegeta.Targeter {
+	return func() (*vegeta.Target, error) {
+		tgt, err := tr()
+		if err == nil && tgt.Body == nil && tgt.BodyFile == "" {
+			tgt.BodyFile = name
+		}
+		return tgt, err
+	}
+}
+
+// pacer returns the vegeta.Pacer selected and configured by the
+// attack options. The replay pacer needs all targets, in order.
+func pacer(opts *attackOpts, tgts []*vegeta.Target) (vegeta.Pacer, error) {
+	rate := opts.rate.PerSecond()
+	switch opts.pacer {
+	case "constant":
+		return opts.rate, nil
+	case "linear":
+		return vegeta.LinearPacer{Start: rate, Slope: opts.slope}, nil
+	case "step":
+		return vegeta.StepPacer{Start: rate, Step: opts.step, Every: opts.stepEvery}, nil
+	case "sine":
+		if math.Abs(opts.sineAmp) > rate {
+			return nil, errSineAmp
+		}
+		return vegeta.SinePacer{Period: opts.sinePeriod, Mean: rate, Amp: opts.sineAmp}, nil
+	case "replay":
+		if opts.speed <= 0 {
+			return nil, fmt.Errorf("bad replay speed: %g", opts.speed)
+		}
+		offsets := make([]time.Duration, len(tgts))
+		for i, tgt := range tgts {
+			offsets[i] = tgt.Offset
+		}
+		return vegeta.ReplayPacer{Offsets: offsets, Speed: opts.speed}, nil
+	default:
+		return nil, fmt.Errorf("bad pacer: %s", opts.pacer)
+	}
+}
+
+// checks returns the vegeta.Checkers defined by the assertion options.
+func checks(opts *assertOpts) ([]vegeta.Checker, error) {
+	var cs []vegeta.Checker
+	if opts.status != "" {
+		var codes []int
+		for _, code := range strings.Split(opts.status, ",") {
+			c, err := strconv.Atoi(strings.TrimSpace(code))
+			if err != nil {
+				return nil, fmt.Errorf("bad status code: %s", code)
+			}
+			codes = append(codes, c)
+		}
+		cs = append(cs, vegeta.CheckStatus(codes...))
+	}
+	for key, vs := range opts.header.Header {
+		for _, v := range vs {
+			cs = append(cs, vegeta.CheckHeader(key, v))
+		}
+	}
+	if opts.body != "" {
+		cs = append(cs, vegeta.CheckBodyContains(opts.body))
+	}
+	if opts.bodyRegexp != "" {
+		re, err := regexp.Compile(opts.bodyRegexp)
+		if err != nil {
+			return nil, fmt.Errorf("bad body regular expression: %s", err)
+		}
+		cs = append(cs, vegeta.CheckBodyMatches(re))
+	}
+	for _, kv := range opts.json {
+		cs = append(cs, vegeta.CheckJSON(kv[0], kv[1]))
+	}
+	if opts.latency > 0 {
+		cs = append(cs, vegeta.CheckLatency(opts.latency))
+	}
+	return cs, nil
+}
+
 // headers is the http.Header used in each target request
 // it is defined here to implement the flag.Value interface
 // in order to support multiple identical flags for request header
@@ -187,6 +399,27 @@ func (h headers) Set(value string) error {
 	return nil
 }
 
+// jsonAsserts implements the flag.Value interface for parsing multiple
+// path=value JSON assertions
+type jsonAsserts [][2]string
+
+func (j jsonAsserts) String() string {
+	strs := make([]string, len(j))
+	for i, kv := range j {
+		strs[i] = kv[0] + "=" + kv[1]
+	}
+	return strings.Join(strs, ", ")
+}
+
+func (j *jsonAsserts) Set(value string) error {
+	parts := strings.SplitN(value, "=", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return fmt.Errorf("JSON assertion '%s' has a wrong format", value)
+	}
+	*j = append(*j, [2]string{parts[0], parts[1]})
+	return nil
+}
+
 // localAddr implements the Flag interface for parsing net.IPAddr
 type localAddr struct{ *net.IPAddr }
 
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"text/tabwriter"
+
+	vegeta "github.com/tsenart/vegeta/lib"
+)
+
+func checkCmd() command {
+	fs := flag.NewFlagSet("vegeta check", flag.ExitOnError)
+	targets := fs.String("targets", "stdin", "Targets file")
+	output := fs.String("output", "stdout", "Output file")
+	return command{fs, func(args []string) error {
+		fs.Parse(args)
+		return check(*targets, *output)
+	}}
+}
+
+// check parses all targets in the targets file, writes every error found
+// along with its position and a summary of the targets, and fails if any
+// of them is invalid.
+func check(targets, output string) error {
+	src, err := file(targets, false)
+	if err != nil {
+		return fmt.Errorf("error opening %s: %s", targets, err)
+	}
+	defer src.Close()
+
+	out, err := file(output, true)
+	if err != nil {
+		return fmt.Errorf("error opening %s: %s", output, err)
+	}
+	defer out.Close()
+
+	var (
+		valid, invalid int
+		methods        = map[string]int{}
+		hosts          = map[string]int{}
+	)
+	tr := vegeta.NewLazyTargeter(src, nil, nil)
+	for {
+		tgt, err := tr()
+		if err == vegeta.ErrNoTargets {
+			break
+		} else if perr, ok := err.(*vegeta.ParseError); ok {
+			fmt.Fprintf(out, "%s:%d:%d: %s\n", targets, perr.Line, perr.Column, perr.Err)
+			invalid++
+			continue
+		} else if err != nil {
+			// The rest of the targets can't be read.
+			return fmt.Errorf("%s: %s", targets, err)
+		}
+		valid++
+		methods[tgt.Method]++
+		if u, err := url.Parse(tgt.URL); err == nil {
+			hosts[u.Host]++
+		}
+	}
+
+	w := tabwriter.NewWriter(out, 0, 8, 2, '\t', tabwriter.StripEscape)
+	fmt.Fprintf(w, "Targets\t[total, valid, invalid]\t%d, %d, %d\n", valid+invalid, valid, invalid)
+	fmt.Fprintf(w, "Methods\t[method:count]\t")
+	writeCounts(w, methods)
+	fmt.Fprintf(w, "Hosts\t[host:count]\t")
+	writeCounts(w, hosts)
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	if invalid > 0 {
+		return fmt.Errorf("%d invalid targets", invalid)
+	}
+	return nil
+}
+
+// writeCounts writes the given counts sorted by key in a single line.
+func writeCounts(w io.Writer, counts map[string]int) {
+	keys := make([]string, 0, len(counts))
+	for k := range counts {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(w, "%s:%d  ", k, counts[k])
+	}
+	fmt.Fprintln(w)
+}
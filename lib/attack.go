@@ -1,52 +1,87 @@
 package vegeta
 
 import (
+	"context"
 	"crypto/tls"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"math"
 	"net"
 	"net/http"
+	"net/http/httptrace"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 // Attacker is an attack executor which wraps an http.Client
 type Attacker struct {
-	dialer  *net.Dialer
-	client  http.Client
-	stop    chan struct{}
-	workers uint64
+	dialer     *net.Dialer
+	client     http.Client
+	stop       chan struct{}
+	stopOnce   sync.Once
+	kill       chan struct{}
+	killOnce   sync.Once
+	workers    uint64
+	maxWorkers uint64
+	maxHits    uint64
+	capture    CaptureMode
+	maxBody    int64
+	checks     []Checker
+	checkBody  bool
+	chunked    bool
+	redirects  int
+	timeout    time.Duration
 }
 
 var (
 	// DefaultRedirects is the default number of times an Attacker follows
 	// redirects.
 	DefaultRedirects = 10
-	// DefaultTimeout is the default amount of time an Attacker waits for a request
-	// before it times out.
+	// DefaultTimeout is the default maximum amount of time each hit of an
+	// Attacker can take, from dialing to reading the response body.
 	DefaultTimeout = 30 * time.Second
 	// DefaultLocalAddr is the default local IP address an Attacker uses.
 	DefaultLocalAddr = net.IPAddr{IP: net.IPv4zero}
 	// DefaultTLSConfig is the default tls.Config an Attacker uses.
 	DefaultTLSConfig = &tls.Config{InsecureSkipVerify: true}
+	// DefaultMaxBody is the default maximum number of bytes of each response
+	// body an Attacker captures.
+	DefaultMaxBody int64 = 64 * 1024
+	// DefaultWorkers is the default initial number of workers an Attacker uses.
+	DefaultWorkers uint64 = 10
+	// MaxCheckBody is the maximum number of bytes of each response body
+	// passed to the Checkers which need it. Longer bodies are truncated.
+	MaxCheckBody int64 = 4 * 1024 * 1024
+	// DefaultMaxWorkers is the default maximum number of workers an Attacker
+	// spawns when all of its workers are busy.
+	DefaultMaxWorkers uint64 = math.MaxUint64
 )
 
 // NewAttacker returns a new Attacker with default options which are overridden
 // by the optionally provided opts.
 func NewAttacker(opts ...func(*Attacker)) *Attacker {
-	a := &Attacker{stop: make(chan struct{})}
+	a := &Attacker{
+		stop:       make(chan struct{}),
+		kill:       make(chan struct{}),
+		workers:    DefaultWorkers,
+		maxWorkers: DefaultMaxWorkers,
+		maxBody:    DefaultMaxBody,
+		redirects:  DefaultRedirects,
+		timeout:    DefaultTimeout,
+	}
 	a.dialer = &net.Dialer{
 		LocalAddr: &net.TCPAddr{IP: DefaultLocalAddr.IP, Zone: DefaultLocalAddr.Zone},
 		KeepAlive: 30 * time.Second,
-		Timeout:   DefaultTimeout,
 	}
 	a.client = http.Client{
+		CheckRedirect: a.checkRedirect,
 		Transport: &http.Transport{
-			Proxy: http.ProxyFromEnvironment,
-			Dial:  a.dialer.Dial,
-			ResponseHeaderTimeout: DefaultTimeout,
-			TLSClientConfig:       DefaultTLSConfig,
-			TLSHandshakeTimeout:   10 * time.Second,
+			Proxy:               http.ProxyFromEnvironment,
+			DialContext:         a.dialer.DialContext,
+			TLSClientConfig:     DefaultTLSConfig,
+			TLSHandshakeTimeout: 10 * time.Second,
 		},
 	}
 	for _, opt := range opts {
@@ -55,36 +90,92 @@ func NewAttacker(opts ...func(*Attacker)) *Attacker {
 	return a
 }
 
-// Workers returns a functional option which sets the number of workers
-// an Attacker uses to hit its targets.
-// If zero or greater than the total number of hits, workers will be capped
-// to that maximum.
+// Workers returns a functional option which sets the initial number of workers
+// an Attacker uses to hit its targets. More workers may be spawned dynamically,
+// up to MaxWorkers, to sustain the requested rate when targets are slow.
 func Workers(n uint64) func(*Attacker) {
 	return func(a *Attacker) { a.workers = n }
 }
 
+// MaxWorkers returns a functional option which sets the maximum number of
+// workers an Attacker can use to hit its targets. Once reached, hits are
+// delayed until a worker becomes available, which is reflected in their
+// Latency since it is measured from the time each hit was scheduled.
+// Zero means no limit.
+func MaxWorkers(n uint64) func(*Attacker) {
+	return func(a *Attacker) {
+		if a.maxWorkers = n; n == 0 {
+			a.maxWorkers = math.MaxUint64
+		}
+	}
+}
+
+// MaxHits returns a functional option which sets the maximum number of hits
+// an Attacker sends in each attack, regardless of its duration.
+// Zero means no limit.
+func MaxHits(n uint64) func(*Attacker) {
+	return func(a *Attacker) { a.maxHits = n }
+}
+
+// Capture returns a functional option which sets which responses an
+// Attacker captures the headers and body of into their Results.
+func Capture(mode CaptureMode) func(*Attacker) {
+	return func(a *Attacker) { a.capture = mode }
+}
+
+// MaxBody returns a functional option which sets the maximum number of bytes
+// of each response body an Attacker captures. A negative n means no limit.
+func MaxBody(n int64) func(*Attacker) {
+	return func(a *Attacker) { a.maxBody = n }
+}
+
+// Checks returns a functional option which adds Checkers an Attacker runs
+// against every response, in order. The first one to fail marks the hit as
+// failed and has its error recorded in the hit's Result. Response bodies are
+// only buffered when a Checker needs them.
+func Checks(cs ...Checker) func(*Attacker) {
+	return func(a *Attacker) {
+		a.checks = append(a.checks, cs...)
+		for _, c := range cs {
+			a.checkBody = a.checkBody || c.body
+		}
+	}
+}
+
+// Chunked returns a functional option which makes an Attacker send request
+// bodies with chunked transfer encoding instead of a Content-Length.
+func Chunked(chunked bool) func(*Attacker) {
+	return func(a *Attacker) { a.chunked = chunked }
+}
+
 // Redirects returns a functional option which sets the maximum
 // number of redirects an Attacker will follow.
 func Redirects(n int) func(*Attacker) {
-	return func(a *Attacker) {
-		a.client.CheckRedirect = func(_ *http.Request, via []*http.Request) error {
-			if len(via) > n {
-				return fmt.Errorf("stopped after %d redirects", n)
-			}
-			return nil
-		}
+	return func(a *Attacker) { a.redirects = n }
+}
+
+// redirectsKey is the context key of the maximum number of redirects to
+// follow for a given request, overriding the Attacker's.
+type redirectsKey struct{}
+
+// checkRedirect stops following redirects after the maximum number of them
+// for the request, set on its context, or the Attacker's.
+func (a *Attacker) checkRedirect(req *http.Request, via []*http.Request) error {
+	n := a.redirects
+	if v, ok := req.Context().Value(redirectsKey{}).(int); ok {
+		n = v
+	}
+	if len(via) > n {
+		return fmt.Errorf("stopped after %d redirects", n)
 	}
+	return nil
 }
 
 // Timeout returns a functional option which sets the maximum amount of time
-// an Attacker will wait for a request to be responded to.
+// a hit can take, from dialing to reading the response body, unless its
+// Target has its own. Zero means no timeout.
 func Timeout(d time.Duration) func(*Attacker) {
-	return func(a *Attacker) {
-		tr := a.client.Transport.(*http.Transport)
-		tr.ResponseHeaderTimeout = d
-		a.dialer.Timeout = d
-		tr.Dial = a.dialer.Dial
-	}
+	return func(a *Attacker) { a.timeout = d }
 }
 
 // LocalAddr returns a functional option which sets the local address
@@ -93,7 +184,7 @@ func LocalAddr(addr net.IPAddr) func(*Attacker) {
 	return func(a *Attacker) {
 		tr := a.client.Transport.(*http.Transport)
 		a.dialer.LocalAddr = &net.TCPAddr{IP: addr.IP, Zone: addr.Zone}
-		tr.Dial = a.dialer.Dial
+		tr.DialContext = a.dialer.DialContext
 	}
 }
 
@@ -105,7 +196,7 @@ func KeepAlive(keepalive bool) func(*Attacker) {
 		tr.DisableKeepAlives = !keepalive
 		if !keepalive {
 			a.dialer.KeepAlive = 0
-			tr.Dial = a.dialer.Dial
+			tr.DialContext = a.dialer.DialContext
 		}
 	}
 }
@@ -120,53 +211,177 @@ func TLSConfig(c *tls.Config) func(*Attacker) {
 }
 
 // Attack reads its Targets from the passed Targeter and attacks them at
-// the rate specified for duration time. Results are put into the returned channel
-// as soon as they arrive.
-func (a *Attacker) Attack(tr Targeter, rate uint64, du time.Duration) chan *Result {
-	resc := make(chan *Result)
-	throttle := time.NewTicker(time.Duration(1e9 / rate))
-	hits := rate * uint64(du.Seconds())
-	wrk := a.workers
-	if wrk == 0 || wrk > hits {
-		wrk = hits
-	}
-	share := hits / wrk
+// the rate defined by the Pacer for duration time. Results are put into the
+// returned channel as soon as they arrive. Targets are read in the order of
+// the hits they're sent with, so the n-th hit is always on the n-th Target.
+//
+// A zero duration means the attack runs until it's stopped, the Pacer says
+// so or MaxHits is reached.
+//
+// Every hit is scheduled at its intended time regardless of how many hits are
+// still in flight, so a slow target doesn't lower the effective rate. When all
+// workers are busy, a new one is spawned unless MaxWorkers has been reached.
+//
+// With a zero Rate, the attack is driven by a fixed pool of Workers instead,
+// each hitting again as soon as its previous hit completes.
+func (a *Attacker) Attack(tr Targeter, p Pacer, du time.Duration) chan *Result {
+	return a.AttackContext(context.Background(), tr, p, du)
+}
 
+// AttackContext is like Attack but the attack is bound to the given
+// context.Context which is propagated to every request. Once it's done, no
+// more hits are sent and the ones in flight are cancelled right away.
+func (a *Attacker) AttackContext(ctx context.Context, tr Targeter, p Pacer, du time.Duration) chan *Result {
 	var wg sync.WaitGroup
-	for i := uint64(0); i < wrk; i++ {
+
+	workers := a.workers
+	if workers > a.maxWorkers {
+		workers = a.maxWorkers
+	}
+
+	maxWorkers := a.maxWorkers
+	unpaced := isUnpaced(p)
+	if unpaced {
+		// Never grow the pool beyond its initial size, but have at
+		// least one worker.
+		if maxWorkers = workers; maxWorkers == 0 {
+			maxWorkers = 1
+		}
+	}
+
+	// In-flight hits are cancelled once the attack is killed.
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		defer cancel()
+		select {
+		case <-a.kill:
+		case <-ctx.Done():
+		}
+	}()
+
+	resc := make(chan *Result)
+	ticks := make(chan tick)
+	for i := uint64(0); i < workers; i++ {
 		wg.Add(1)
-		go func(share uint64) {
-			defer wg.Done()
-			for j := uint64(0); j < share; j++ {
+		go a.attack(ctx, &wg, ticks, resc)
+	}
+
+	go func() {
+		defer cancel()
+		defer close(resc)
+		defer wg.Wait()
+		defer close(ticks)
+
+		began := time.Now()
+		for count := uint64(0); a.maxHits == 0 || count < a.maxHits; count++ {
+			elapsed := time.Since(began)
+			wait, stop := p.Pace(elapsed, count)
+			if stop || du > 0 && elapsed+wait >= du {
+				return
+			}
+
+			// Unpaced hits aren't due at any particular time, so they're
+			// timed from when a worker picks them up.
+			var tm time.Time
+			if !unpaced {
+				tm = began.Add(elapsed + wait)
+			}
+			if wait > 0 {
 				select {
-				case tm := <-throttle.C:
-					resc <- a.hit(tr, tm)
+				case <-time.After(wait):
 				case <-a.stop:
 					return
+				case <-ctx.Done():
+					return
 				}
 			}
-		}(share)
-	}
 
-	go func() {
-		wg.Wait()
-		close(resc)
-		throttle.Stop()
+			// Targets are read here, in order, rather than by the workers
+			// so that every hit gets the Target its Pacer timed it for, as
+			// when replaying.
+			t := tick{tm: tm}
+			t.tgt, t.err = tr()
+
+			if workers < maxWorkers {
+				select {
+				case ticks <- t:
+					continue
+				case <-a.stop:
+					return
+				case <-ctx.Done():
+					return
+				default:
+					// All workers are busy, start one more.
+					workers++
+					wg.Add(1)
+					go a.attack(ctx, &wg, ticks, resc)
+				}
+			}
+
+			select {
+			case ticks <- t:
+			case <-a.stop:
+				return
+			case <-ctx.Done():
+				return
+			}
+		}
 	}()
 
 	return resc
 }
 
-// Stop stops the current attack.
-func (a *Attacker) Stop() { close(a.stop) }
+// isUnpaced returns whether p is a zero Rate, by value or by pointer, which
+// has no rate limit.
+func isUnpaced(p Pacer) bool {
+	switch r := p.(type) {
+	case Rate:
+		return r.Freq == 0
+	case *Rate:
+		return r != nil && r.Freq == 0
+	}
+	return false
+}
+
+// tick is a scheduled hit on a Target, or with the error of reading it.
+type tick struct {
+	tm  time.Time
+	tgt *Target
+	err error
+}
 
-func (a *Attacker) hit(tr Targeter, tm time.Time) *Result {
+func (a *Attacker) attack(ctx context.Context, wg *sync.WaitGroup, ticks <-chan tick, resc chan<- *Result) {
+	defer wg.Done()
+	for t := range ticks {
+		if t.tm.IsZero() {
+			t.tm = time.Now()
+		}
+		resc <- a.hit(ctx, t)
+	}
+}
+
+// Stop stops the current attack. No more hits are sent and the ones in
+// flight are given up to drain time to complete before they're cancelled.
+// The results channel is closed once all of them are done, so callers must
+// keep receiving from it until then. Stop can be called again, e.g. with
+// a zero drain to cancel in-flight hits right away.
+func (a *Attacker) Stop(drain time.Duration) {
+	a.stopOnce.Do(func() { close(a.stop) })
+	kill := func() { a.killOnce.Do(func() { close(a.kill) }) }
+	if drain <= 0 {
+		kill()
+	} else {
+		time.AfterFunc(drain, kill)
+	}
+}
+
+func (a *Attacker) hit(ctx context.Context, tk tick) *Result {
+	tm, tgt := tk.tm, tk.tgt
 	res := Result{Timestamp: tm}
 	defer func() { res.Latency = time.Since(tm) }()
 
-	tgt, err := tr()
-	if err != nil {
-		res.Error = err.Error()
+	if tk.err != nil {
+		res.Error = tk.err.Error()
 		return &res
 	}
 
@@ -176,21 +391,99 @@ func (a *Attacker) hit(tr Targeter, tm time.Time) *Result {
 		return &res
 	}
 
-	r, err := a.client.Do(req)
+	// Targets can override the Attacker's timeout, for the whole hit, and
+	// the number of redirects to follow.
+	timeout := a.timeout
+	if tgt.Timeout > 0 {
+		timeout = tgt.Timeout
+	}
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	if tgt.Redirects != nil {
+		ctx = context.WithValue(ctx, redirectsKey{}, *tgt.Redirects)
+	}
+
+	var out *countingReadCloser
+	if a.chunked && req.Body != nil && req.Body != http.NoBody {
+		out = &countingReadCloser{ReadCloser: req.Body}
+		req.Body, req.ContentLength = out, -1
+		req.TransferEncoding = []string{"chunked"}
+	}
+
+	var t tracer
+	r, err := a.client.Do(req.WithContext(httptrace.WithClientTrace(ctx, t.clientTrace())))
 	if err != nil {
+		t.record(&res, time.Time{})
 		res.Error = err.Error()
 		return &res
 	}
 	defer r.Body.Close()
 
-	res.BytesOut = uint64(req.ContentLength)
+	if out != nil {
+		res.BytesOut = atomic.LoadUint64(&out.n)
+	} else {
+		res.BytesOut = uint64(req.ContentLength)
+	}
 	res.Code = uint16(r.StatusCode)
-	if body, err := ioutil.ReadAll(r.Body); err != nil {
-		if res.Code < 200 || res.Code >= 400 {
-			res.Error = string(body)
+
+	// The body is only buffered when it may need to be checked or captured,
+	// up to the most either of them needs.
+	var w io.Writer = ioutil.Discard
+	buf := cappedBuffer{max: a.maxBody}
+	if a.capture != CaptureNone {
+		w = &buf
+	}
+	if a.checkBody {
+		w = &buf
+		if a.capture == CaptureNone || a.maxBody >= 0 && a.maxBody < MaxCheckBody {
+			buf.max = MaxCheckBody
 		}
-	} else {
-		res.BytesIn = uint64(len(body))
+	}
+	n, err := io.Copy(w, r.Body)
+	t.record(&res, time.Now())
+	res.BytesIn = uint64(n)
+	if err != nil {
+		res.Error = err.Error()
+	}
+
+	body := buf.Bytes()
+	for i := 0; i < len(a.checks) && res.Error == ""; i++ {
+		c := a.checks[i]
+		var checked []byte
+		if c.body {
+			if checked = body; int64(len(checked)) > MaxCheckBody {
+				checked = checked[:MaxCheckBody]
+			}
+		}
+		if err = c.Check(r, checked, time.Since(tm)); err != nil {
+			res.Error = err.Error()
+		} else if c.status != nil {
+			res.Expected = true
+		}
+	}
+
+	if a.capture.captures(&res) {
+		if a.maxBody >= 0 && int64(len(body)) > a.maxBody {
+			body = body[:a.maxBody]
+		}
+		res.Header = r.Header
+		res.Body = body
 	}
 	return &res
 }
+
+// countingReadCloser counts the bytes read from an io.ReadCloser, which may
+// happen concurrently with the reading of the count.
+type countingReadCloser struct {
+	io.ReadCloser
+	n uint64
+}
+
+func (c *countingReadCloser) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	atomic.AddUint64(&c.n, uint64(n))
+	return n, err
+}
@@ -1,10 +1,15 @@
 package vegeta
 
 import (
+	"context"
 	"fmt"
+	"io/ioutil"
 	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
 	"strings"
 	"sync/atomic"
 	"testing"
@@ -21,7 +26,7 @@ func TestAttackRate(t *testing.T) {
 	rate := uint64(100)
 	atk := NewAttacker()
 	var hits uint64
-	for _ = range atk.Attack(tr, rate, 1*time.Second) {
+	for _ = range atk.Attack(tr, Rate{Freq: rate, Per: time.Second}, 1*time.Second) {
 		hits++
 	}
 	if hits != rate {
@@ -29,6 +34,507 @@ func TestAttackRate(t *testing.T) {
 	}
 }
 
+func TestAttackRateWithSlowTargets(t *testing.T) {
+	t.Parallel()
+
+	server := httptest.NewServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			time.Sleep(100 * time.Millisecond)
+		}),
+	)
+	tr := NewStaticTargeter(&Target{Method: "GET", URL: server.URL})
+	atk := NewAttacker(Workers(1))
+	rate := uint64(100)
+	interval := time.Second / time.Duration(rate)
+
+	var hits uint64
+	var began time.Time
+	for res := range atk.Attack(tr, Rate{Freq: rate, Per: time.Second}, 1*time.Second) {
+		if res.Error != "" {
+			t.Fatal(res.Error)
+		}
+		if hits == 0 || res.Timestamp.Before(began) {
+			began = res.Timestamp
+		}
+		if offset := res.Timestamp.Sub(began); offset%interval != 0 {
+			t.Fatalf("Hit not scheduled at its intended time: offset %s", offset)
+		}
+		hits++
+	}
+	if hits != rate {
+		t.Fatalf("Wrong number of hits: want %d, got %d\n", rate, hits)
+	}
+}
+
+func TestMaxWorkers(t *testing.T) {
+	t.Parallel()
+
+	var inflight, max int64
+	server := httptest.NewServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			n := atomic.AddInt64(&inflight, 1)
+			defer atomic.AddInt64(&inflight, -1)
+			for m := atomic.LoadInt64(&max); n > m; m = atomic.LoadInt64(&max) {
+				if atomic.CompareAndSwapInt64(&max, m, n) {
+					break
+				}
+			}
+			time.Sleep(50 * time.Millisecond)
+		}),
+	)
+	tr := NewStaticTargeter(&Target{Method: "GET", URL: server.URL})
+	atk := NewAttacker(Workers(1), MaxWorkers(2))
+
+	for res := range atk.Attack(tr, Rate{Freq: 100, Per: time.Second}, 500*time.Millisecond) {
+		if res.Error != "" {
+			t.Fatal(res.Error)
+		}
+	}
+	if max > 2 {
+		t.Fatalf("Too many concurrent requests: want <= %d, got %d", 2, max)
+	}
+}
+
+func TestZeroMaxWorkers(t *testing.T) {
+	t.Parallel()
+
+	server := httptest.NewServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}),
+	)
+	tr := NewStaticTargeter(&Target{Method: "GET", URL: server.URL})
+	atk := NewAttacker(Workers(0), MaxWorkers(0))
+	time.AfterFunc(2*time.Second, func() { atk.Stop(0) })
+
+	var hits int
+	for res := range atk.Attack(tr, Rate{Freq: 10, Per: time.Second}, 200*time.Millisecond) {
+		if res.Error != "" {
+			t.Fatal(res.Error)
+		}
+		hits++
+	}
+	if hits != 2 {
+		t.Fatalf("Wrong number of hits: want %d, got %d\n", 2, hits)
+	}
+}
+
+func TestMaxHits(t *testing.T) {
+	t.Parallel()
+
+	server := httptest.NewServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}),
+	)
+	tr := NewStaticTargeter(&Target{Method: "GET", URL: server.URL})
+	atk := NewAttacker(MaxHits(10))
+	var hits uint64
+	for _ = range atk.Attack(tr, Rate{Freq: 1000, Per: time.Second}, 0) {
+		hits++
+	}
+	if hits != 10 {
+		t.Fatalf("Wrong number of hits: want %d, got %d\n", 10, hits)
+	}
+}
+
+func TestUnboundedAttack(t *testing.T) {
+	t.Parallel()
+
+	server := httptest.NewServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}),
+	)
+	tr := NewStaticTargeter(&Target{Method: "GET", URL: server.URL})
+	atk := NewAttacker()
+	time.AfterFunc(500*time.Millisecond, func() { atk.Stop(0) })
+
+	var hits uint64
+	for _ = range atk.Attack(tr, Rate{Freq: 100, Per: time.Second}, 0) {
+		hits++
+	}
+	if hits < 40 || hits > 60 {
+		t.Fatalf("Wrong number of hits: want ~%d, got %d\n", 50, hits)
+	}
+}
+
+func TestAttackMaxRate(t *testing.T) {
+	t.Parallel()
+
+	var inflight, max int64
+	server := httptest.NewServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			n := atomic.AddInt64(&inflight, 1)
+			defer atomic.AddInt64(&inflight, -1)
+			for m := atomic.LoadInt64(&max); n > m; m = atomic.LoadInt64(&max) {
+				if atomic.CompareAndSwapInt64(&max, m, n) {
+					break
+				}
+			}
+			time.Sleep(10 * time.Millisecond)
+		}),
+	)
+	tr := NewStaticTargeter(&Target{Method: "GET", URL: server.URL})
+
+	// A zero Rate is unpaced whether it's passed by value or by pointer.
+	for _, p := range []Pacer{Rate{}, &Rate{}} {
+		atomic.StoreInt64(&max, 0)
+		atk := NewAttacker(Workers(2))
+
+		var hits uint64
+		for res := range atk.Attack(tr, p, 500*time.Millisecond) {
+			if res.Error != "" {
+				t.Fatal(res.Error)
+			}
+			if res.Latency < 10*time.Millisecond || res.Latency > 100*time.Millisecond {
+				t.Fatalf("Latency must only include the request: got %s", res.Latency)
+			}
+			hits++
+		}
+		if max != 2 {
+			t.Fatalf("%T: Wrong number of concurrent requests: want %d, got %d", p, 2, max)
+		}
+		if hits < 20 {
+			t.Fatalf("%T: Too few hits: want at least %d, got %d", p, 20, hits)
+		}
+	}
+}
+
+func TestStopDrain(t *testing.T) {
+	t.Parallel()
+
+	server := httptest.NewServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			time.Sleep(200 * time.Millisecond)
+		}),
+	)
+	tr := NewStaticTargeter(&Target{Method: "GET", URL: server.URL})
+	atk := NewAttacker()
+	time.AfterFunc(100*time.Millisecond, func() { atk.Stop(time.Second) })
+
+	var hits uint64
+	for res := range atk.Attack(tr, Rate{Freq: 100, Per: time.Second}, 0) {
+		if res.Error != "" {
+			t.Fatalf("In-flight hits must be drained: got %s", res.Error)
+		}
+		hits++
+	}
+	if hits < 5 || hits > 15 {
+		t.Fatalf("Wrong number of hits: want ~%d, got %d\n", 10, hits)
+	}
+}
+
+func TestStopCancel(t *testing.T) {
+	t.Parallel()
+
+	done := make(chan struct{})
+	defer close(done)
+	server := httptest.NewServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case <-done:
+			case <-time.After(5 * time.Second):
+			}
+		}),
+	)
+	tr := NewStaticTargeter(&Target{Method: "GET", URL: server.URL})
+	atk := NewAttacker()
+	time.AfterFunc(100*time.Millisecond, func() { atk.Stop(100 * time.Millisecond) })
+
+	began := time.Now()
+	for res := range atk.Attack(tr, Rate{Freq: 100, Per: time.Second}, 0) {
+		if !strings.Contains(res.Error, context.Canceled.Error()) {
+			t.Fatalf("In-flight hits must be cancelled: got %q", res.Error)
+		}
+	}
+	if elapsed := time.Since(began); elapsed > time.Second {
+		t.Fatalf("Attack wasn't stopped after the drain timeout: took %s", elapsed)
+	}
+}
+
+func TestAttackReplay(t *testing.T) {
+	t.Parallel()
+
+	server := httptest.NewServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Target", r.URL.Query().Get("i"))
+		}),
+	)
+
+	// Hits are due close together, so that they're picked up by workers
+	// concurrently, and each must still be sent with its own Target.
+	tgts := make([]*Target, 100)
+	offsets := make([]time.Duration, len(tgts))
+	for i := range tgts {
+		offsets[i] = time.Duration(i) * 100 * time.Microsecond
+		tgts[i] = &Target{Method: "GET", URL: fmt.Sprintf("%s/?i=%d", server.URL, i), Offset: offsets[i]}
+	}
+
+	atk := NewAttacker(Workers(10), Capture(CaptureAll))
+	var results Results
+	for res := range atk.Attack(NewStaticTargeter(tgts...), ReplayPacer{Offsets: offsets}, 0) {
+		results = append(results, res)
+	}
+	if len(results) != len(tgts) {
+		t.Fatalf("want %d results, got %d", len(tgts), len(results))
+	}
+
+	sort.Sort(results)
+	began := results[0].Timestamp
+	for _, res := range results {
+		i, err := strconv.Atoi(res.Header.Get("X-Target"))
+		if err != nil {
+			t.Fatalf("bad result: %+v", res)
+		}
+		if at := res.Timestamp.Sub(began); at != offsets[i] {
+			t.Errorf("target %d: want it sent at %s, got %s", i, offsets[i], at)
+		}
+	}
+}
+
+func TestAttackContext(t *testing.T) {
+	t.Parallel()
+
+	done := make(chan struct{})
+	defer close(done)
+	server := httptest.NewServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case <-done:
+			case <-time.After(5 * time.Second):
+			}
+		}),
+	)
+	tr := NewStaticTargeter(&Target{Method: "GET", URL: server.URL})
+	atk := NewAttacker()
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	began := time.Now()
+	var results uint64
+	for res := range atk.AttackContext(ctx, tr, Rate{Freq: 100, Per: time.Second}, 0) {
+		if !strings.Contains(res.Error, context.DeadlineExceeded.Error()) {
+			t.Fatalf("In-flight hits must be cancelled: got %q", res.Error)
+		}
+		results++
+	}
+	if elapsed := time.Since(began); elapsed > time.Second {
+		t.Fatalf("Attack wasn't cancelled with its context: took %s", elapsed)
+	}
+	if results < 15 || results > 25 {
+		t.Fatalf("Wrong number of results: want ~%d, got %d", 20, results)
+	}
+}
+
+func TestPhases(t *testing.T) {
+	t.Parallel()
+
+	server := httptest.NewTLSServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			time.Sleep(10 * time.Millisecond)
+		}),
+	)
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u.Host = "localhost:" + u.Port()
+
+	tr := NewStaticTargeter(&Target{Method: "GET", URL: u.String()})
+	atk := NewAttacker(Workers(1), MaxWorkers(1))
+	results := Results{}
+	for res := range atk.Attack(tr, Rate{Freq: 10, Per: time.Second}, 300*time.Millisecond) {
+		if res.Error != "" {
+			t.Fatal(res.Error)
+		}
+		results = append(results, res)
+	}
+	sort.Sort(results)
+
+	if len(results) != 3 {
+		t.Fatalf("Wrong number of results: want %d, got %d", 3, len(results))
+	}
+	first := results[0]
+	if first.Reused || first.DNS == 0 || first.Connect == 0 || first.TLS == 0 {
+		t.Errorf("First hit must set up a new connection: %+v", first)
+	}
+	for _, res := range results {
+		if res.FirstByte < 10*time.Millisecond || res.BodyRead < 0 {
+			t.Errorf("Bad response phases: %+v", res)
+		}
+	}
+	for _, res := range results[1:] {
+		if !res.Reused || res.Connect != 0 || res.TLS != 0 {
+			t.Errorf("Subsequent hits must reuse the connection: %+v", res)
+		}
+	}
+}
+
+func TestCapture(t *testing.T) {
+	t.Parallel()
+
+	server := httptest.NewServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/fail" {
+				w.Header().Set("X-Error", "1")
+				w.WriteHeader(http.StatusBadGateway)
+			}
+			w.Write([]byte(r.URL.Path + " response"))
+		}),
+	)
+	tr := NewStaticTargeter(
+		&Target{Method: "GET", URL: server.URL + "/ok"},
+		&Target{Method: "GET", URL: server.URL + "/fail"},
+	)
+
+	for mode, want := range map[CaptureMode]map[uint16]string{
+		CaptureNone:   {200: "", 502: ""},
+		CaptureErrors: {200: "", 502: "/fail"},
+		CaptureAll:    {200: "/ok r", 502: "/fail"},
+	} {
+		atk := NewAttacker(Capture(mode), MaxBody(5))
+		for res := range atk.Attack(tr, Rate{Freq: 10, Per: time.Second}, 200*time.Millisecond) {
+			if got := string(res.Body); got != want[res.Code] {
+				t.Errorf("%s: %d: want body %q, got %q", mode, res.Code, want[res.Code], got)
+			}
+			if captured := res.Header != nil; captured != (want[res.Code] != "") {
+				t.Errorf("%s: %d: headers captured: %t", mode, res.Code, captured)
+			} else if captured && res.Code == 502 && res.Header.Get("X-Error") != "1" {
+				t.Errorf("%s: %d: bad headers: %v", mode, res.Code, res.Header)
+			}
+			if want := uint64(len(res.Body)); res.BytesIn < want {
+				t.Errorf("%s: %d: want all bytes counted, got %d", mode, res.Code, res.BytesIn)
+			}
+		}
+	}
+}
+
+func TestChecks(t *testing.T) {
+	t.Parallel()
+
+	server := httptest.NewServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/fail" {
+				w.Write([]byte(`{"error": "outage"}`))
+				return
+			}
+			w.Write([]byte(`{"error": null}`))
+		}),
+	)
+	tr := NewStaticTargeter(
+		&Target{Method: "GET", URL: server.URL + "/ok"},
+		&Target{Method: "GET", URL: server.URL + "/fail"},
+	)
+	atk := NewAttacker(
+		Checks(CheckStatus(200), CheckJSON("error", "null")),
+		Capture(CaptureErrors),
+	)
+
+	var results Results
+	for res := range atk.Attack(tr, Rate{Freq: 10, Per: time.Second}, time.Second) {
+		if res.Code != 200 {
+			t.Fatalf("Wrong status code: want %d, got %d", 200, res.Code)
+		}
+		results = append(results, res)
+	}
+
+	var failed int
+	for _, res := range results {
+		if res.Error == "" {
+			continue
+		}
+		failed++
+		if want := "unexpected JSON value: error != null"; res.Error != want {
+			t.Errorf("Wrong error: want %q, got %q", want, res.Error)
+		}
+		if want := `{"error": "outage"}`; string(res.Body) != want {
+			t.Errorf("Failed responses must be captured: want %q, got %q", want, res.Body)
+		}
+	}
+	if failed != len(results)/2 {
+		t.Errorf("Wrong number of failed hits: want %d, got %d", len(results)/2, failed)
+	}
+	if m := NewMetrics(results); m.Success != 0.5 {
+		t.Errorf("Failed checks must count as unsuccessful: got %f", m.Success)
+	}
+}
+
+func TestCheckStatusSuccess(t *testing.T) {
+	t.Parallel()
+
+	server := httptest.NewServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNotFound)
+		}),
+	)
+	tr := NewStaticTargeter(&Target{Method: "DELETE", URL: server.URL})
+	atk := NewAttacker(Checks(CheckStatus(404)), Capture(CaptureErrors))
+
+	var results Results
+	for res := range atk.Attack(tr, Rate{Freq: 10, Per: time.Second}, 300*time.Millisecond) {
+		if res.Error != "" || !res.Expected || res.Header != nil {
+			t.Errorf("want expected, uncaptured 404, got %+v", res)
+		}
+		results = append(results, res)
+	}
+	if m := NewMetrics(results); m.Success != 1 {
+		t.Errorf("Expected status codes must count as successful: got %f", m.Success)
+	}
+}
+
+func TestChecksBody(t *testing.T) {
+	t.Parallel()
+
+	big := strings.Repeat("x", int(MaxCheckBody)+1024)
+	server := httptest.NewServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(big))
+		}),
+	)
+	tr := NewStaticTargeter(&Target{Method: "GET", URL: server.URL})
+
+	var sizes [2]int64
+	atk := NewAttacker(Checks(
+		NewChecker(false, func(_ *http.Response, body []byte, _ time.Duration) error {
+			atomic.StoreInt64(&sizes[0], int64(len(body)))
+			return nil
+		}),
+		NewChecker(true, func(_ *http.Response, body []byte, _ time.Duration) error {
+			atomic.StoreInt64(&sizes[1], int64(len(body)))
+			return nil
+		}),
+	))
+	for res := range atk.Attack(tr, Rate{Freq: 10, Per: time.Second}, 100*time.Millisecond) {
+		if res.Error != "" || res.BytesIn != uint64(len(big)) {
+			t.Fatalf("want %d bytes read without errors, got %d and %q", len(big), res.BytesIn, res.Error)
+		}
+	}
+	if sizes[0] != 0 || sizes[1] != MaxCheckBody {
+		t.Errorf("want bodies of 0 and %d bytes checked, got %v", MaxCheckBody, sizes)
+	}
+}
+
+func TestChunked(t *testing.T) {
+	t.Parallel()
+
+	server := httptest.NewServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := ioutil.ReadAll(r.Body)
+			if len(r.TransferEncoding) == 0 || r.TransferEncoding[0] != "chunked" {
+				http.Error(w, "not chunked", http.StatusBadRequest)
+				return
+			}
+			w.Write(body)
+		}),
+	)
+	body := []byte(strings.Repeat("vegeta", 1000))
+	tr := NewStaticTargeter(&Target{Method: "POST", URL: server.URL, Body: body})
+	atk := NewAttacker(Chunked(true))
+	for res := range atk.Attack(tr, Rate{Freq: 10, Per: time.Second}, 500*time.Millisecond) {
+		if res.Code != 200 || res.Error != "" {
+			t.Fatalf("Bad response: %d %s", res.Code, res.Error)
+		}
+		if res.BytesOut != uint64(len(body)) || res.BytesIn != uint64(len(body)) {
+			t.Fatalf("Wrong bytes: want %d out and in, got %d and %d", len(body), res.BytesOut, res.BytesIn)
+		}
+	}
+}
+
 func TestDefaultAttackerCertConfig(t *testing.T) {
 	t.Parallel()
 
@@ -61,7 +567,7 @@ func TestRedirects(t *testing.T) {
 	atk := NewAttacker(Redirects(2))
 	tr := NewStaticTargeter(&Target{Method: "GET", URL: servers[0].URL})
 	var rate uint64 = 10
-	results := atk.Attack(tr, rate, 1*time.Second)
+	results := atk.Attack(tr, Rate{Freq: rate, Per: time.Second}, 1*time.Second)
 
 	want := fmt.Sprintf("stopped after %d redirects", 2)
 	for result := range results {
@@ -75,6 +581,41 @@ func TestRedirects(t *testing.T) {
 	}
 }
 
+func TestTargetOverrides(t *testing.T) {
+	t.Parallel()
+
+	server := httptest.NewServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/slow":
+				time.Sleep(50 * time.Millisecond)
+			case "/redirect":
+				http.Redirect(w, r, "/", 302)
+			}
+		}),
+	)
+
+	none := 0
+	for _, tc := range []struct {
+		tgt  Target
+		want string
+	}{
+		{Target{Method: "GET", URL: server.URL + "/slow"}, "context deadline exceeded"},
+		{Target{Method: "GET", URL: server.URL + "/slow", Timeout: 10 * time.Millisecond}, "context deadline exceeded"},
+		{Target{Method: "GET", URL: server.URL + "/slow", Timeout: time.Second}, ""},
+		{Target{Method: "GET", URL: server.URL + "/redirect"}, ""},
+		{Target{Method: "GET", URL: server.URL + "/redirect", Redirects: &none}, "stopped after 0 redirects"},
+	} {
+		atk := NewAttacker(Redirects(1), Timeout(20*time.Millisecond))
+		tgt := tc.tgt
+		for res := range atk.Attack(NewStaticTargeter(&tgt), Rate{Freq: 10, Per: time.Second}, 200*time.Millisecond) {
+			if tc.want == "" && res.Error != "" || !strings.Contains(res.Error, tc.want) {
+				t.Errorf("%s: want error %q, got %q", tgt.URL, tc.want, res.Error)
+			}
+		}
+	}
+}
+
 func TestTimeout(t *testing.T) {
 	t.Parallel()
 
@@ -86,9 +627,9 @@ func TestTimeout(t *testing.T) {
 
 	atk := NewAttacker(Timeout(10 * time.Millisecond))
 	tr := NewStaticTargeter(&Target{Method: "GET", URL: server.URL})
-	results := atk.Attack(tr, 1, 1*time.Second)
+	results := atk.Attack(tr, Rate{Freq: 1, Per: time.Second}, 1*time.Second)
 
-	want := "net/http: timeout awaiting response headers"
+	want := "context deadline exceeded"
 	for result := range results {
 		if !strings.Contains(result.Error, want) {
 			t.Fatalf("Expected error to be: %s, Got: %s", want, result.Error)
@@ -120,7 +661,7 @@ func TestLocalAddr(t *testing.T) {
 	atk := NewAttacker(LocalAddr(*addr))
 	tr := NewStaticTargeter(&Target{Method: "GET", URL: server.URL})
 
-	for result := range atk.Attack(tr, 1, 1*time.Second) {
+	for result := range atk.Attack(tr, Rate{Freq: 1, Per: time.Second}, 1*time.Second) {
 		if result.Error != "" {
 			t.Fatal(result.Error)
 		}
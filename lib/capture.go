@@ -0,0 +1,75 @@
+package vegeta
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// CaptureMode defines which responses an Attacker captures the headers and
+// body of into their Results.
+type CaptureMode int
+
+const (
+	// CaptureNone doesn't capture any responses.
+	CaptureNone CaptureMode = iota
+	// CaptureErrors captures unsuccessful responses, i.e. the ones with
+	// a non 2xx status code not expected by a status check or which
+	// otherwise resulted in an error, including failed checks.
+	CaptureErrors
+	// CaptureAll captures every response.
+	CaptureAll
+)
+
+var captureModes = []string{"none", "errors", "all"}
+
+// Set implements the flag.Value interface.
+func (c *CaptureMode) Set(value string) error {
+	for i, name := range captureModes {
+		if value == name {
+			*c = CaptureMode(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("bad capture mode: %s", value)
+}
+
+// String implements the fmt.Stringer interface.
+func (c CaptureMode) String() string {
+	if c < 0 || int(c) >= len(captureModes) {
+		return fmt.Sprintf("CaptureMode(%d)", int(c))
+	}
+	return captureModes[c]
+}
+
+// captures returns whether the given Result must be captured.
+func (c CaptureMode) captures(res *Result) bool {
+	switch c {
+	case CaptureAll:
+		return true
+	case CaptureErrors:
+		return !res.Success()
+	default:
+		return false
+	}
+}
+
+// cappedBuffer is an io.Writer which buffers at most max bytes of what's
+// written to it and silently discards the rest. A negative max means no cap.
+// It doesn't embed bytes.Buffer so that io.Copy can't bypass Write.
+type cappedBuffer struct {
+	buf bytes.Buffer
+	max int64
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	if left := b.max - int64(b.buf.Len()); b.max >= 0 && left < int64(len(p)) {
+		if left > 0 {
+			b.buf.Write(p[:left])
+		}
+		return len(p), nil
+	}
+	return b.buf.Write(p)
+}
+
+// Bytes returns the buffered bytes.
+func (b *cappedBuffer) Bytes() []byte { return b.buf.Bytes() }
@@ -0,0 +1,156 @@
+package vegeta
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A Checker inspects a response, along with its body and the latency of its
+// hit so far, to decide whether the hit failed beyond what its status code
+// says. A non-nil error marks the hit as failed and is recorded in its Result.
+//
+// Since checks run for every response, the errors they return should not
+// vary between responses that fail for the same reason so that they're
+// grouped together in reports.
+type Checker struct {
+	check  CheckFunc
+	body   bool  // whether the check needs the response body
+	status []int // expected status codes, which make up successful responses
+}
+
+// A CheckFunc is the function of a Checker. The response body is nil unless
+// the Checker needs it, in which case it's at most MaxCheckBody bytes long.
+type CheckFunc func(r *http.Response, body []byte, latency time.Duration) error
+
+// NewChecker returns a Checker which runs the given function, passing it the
+// response body if body is true.
+func NewChecker(body bool, check CheckFunc) Checker {
+	return Checker{check: check, body: body}
+}
+
+// Check runs the Checker against the given response, body and latency.
+func (c Checker) Check(r *http.Response, body []byte, latency time.Duration) error {
+	return c.check(r, body, latency)
+}
+
+// CheckStatus returns a Checker which fails responses whose status code isn't
+// one of the given codes. Responses with those codes count as successful,
+// whether they're 2xx or not.
+func CheckStatus(codes ...int) Checker {
+	c := NewChecker(false, func(r *http.Response, _ []byte, _ time.Duration) error {
+		if expected(codes, r.StatusCode) {
+			return nil
+		}
+		return fmt.Errorf("unexpected status code: %d", r.StatusCode)
+	})
+	c.status = codes
+	return c
+}
+
+func expected(codes []int, code int) bool {
+	for _, c := range codes {
+		if code == c {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckHeader returns a Checker which fails responses without a header with
+// the given key and value. An empty value only checks the header is present.
+func CheckHeader(key, value string) Checker {
+	key = http.CanonicalHeaderKey(key)
+	return NewChecker(false, func(r *http.Response, _ []byte, _ time.Duration) error {
+		vs, ok := r.Header[key]
+		if ok && value == "" {
+			return nil
+		}
+		for _, v := range vs {
+			if v == value {
+				return nil
+			}
+		}
+		if value == "" {
+			return fmt.Errorf("missing header: %s", key)
+		}
+		return fmt.Errorf("unexpected header: %s != %s", key, value)
+	})
+}
+
+// CheckBodyContains returns a Checker which fails responses whose body
+// doesn't contain the given substring.
+func CheckBodyContains(substr string) Checker {
+	return NewChecker(true, func(_ *http.Response, body []byte, _ time.Duration) error {
+		if !bytes.Contains(body, []byte(substr)) {
+			return fmt.Errorf("body doesn't contain: %s", substr)
+		}
+		return nil
+	})
+}
+
+// CheckBodyMatches returns a Checker which fails responses whose body doesn't
+// match the given regular expression.
+func CheckBodyMatches(re *regexp.Regexp) Checker {
+	return NewChecker(true, func(_ *http.Response, body []byte, _ time.Duration) error {
+		if !re.Match(body) {
+			return fmt.Errorf("body doesn't match: %s", re)
+		}
+		return nil
+	})
+}
+
+// CheckJSON returns a Checker which fails responses whose body isn't a JSON
+// document with the given value at the given path. Paths are dot separated
+// object keys and array indexes, like data.items.0.id. Strings are compared
+// as is while any other value is compared with its JSON encoding, e.g. null,
+// true or 42.
+func CheckJSON(path, value string) Checker {
+	keys := strings.Split(path, ".")
+	return NewChecker(true, func(_ *http.Response, body []byte, _ time.Duration) error {
+		var v interface{}
+		if err := json.Unmarshal(body, &v); err != nil {
+			return fmt.Errorf("body isn't JSON: %s", path)
+		}
+		for _, key := range keys {
+			switch node := v.(type) {
+			case map[string]interface{}:
+				v = node[key]
+			case []interface{}:
+				i, err := strconv.Atoi(key)
+				if err != nil || i < 0 || i >= len(node) {
+					return fmt.Errorf("missing JSON value: %s", path)
+				}
+				v = node[i]
+			default:
+				return fmt.Errorf("missing JSON value: %s", path)
+			}
+		}
+
+		got, ok := v.(string)
+		if !ok {
+			bs, _ := json.Marshal(v)
+			got = string(bs)
+		}
+		if got != value {
+			return fmt.Errorf("unexpected JSON value: %s != %s", path, value)
+		}
+		return nil
+	})
+}
+
+// CheckLatency returns a Checker which fails responses which took longer
+// than the given maximum latency.
+func CheckLatency(max time.Duration) Checker {
+	return NewChecker(false, func(_ *http.Response, _ []byte, latency time.Duration) error {
+		if latency > max {
+			return fmt.Errorf("latency above %s", max)
+		}
+		return nil
+	})
+}
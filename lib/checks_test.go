@@ -0,0 +1,52 @@
+package vegeta
+
+import (
+	"net/http"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestCheckers(t *testing.T) {
+	t.Parallel()
+
+	r := &http.Response{
+		StatusCode: 200,
+		Header:     http.Header{"Content-Type": []string{"application/json"}},
+	}
+	body := []byte(`{"error": null, "data": {"items": [{"id": 42, "name": "goku"}]}}`)
+	latency := 100 * time.Millisecond
+
+	for _, tc := range []struct {
+		name  string
+		check Checker
+		fail  bool
+	}{
+		{"status", CheckStatus(200, 204), false},
+		{"status/bad", CheckStatus(201), true},
+		{"header", CheckHeader("content-type", "application/json"), false},
+		{"header/present", CheckHeader("Content-Type", ""), false},
+		{"header/bad", CheckHeader("Content-Type", "text/plain"), true},
+		{"header/missing", CheckHeader("X-Request-Id", ""), true},
+		{"contains", CheckBodyContains(`"goku"`), false},
+		{"contains/bad", CheckBodyContains("vegeta"), true},
+		{"matches", CheckBodyMatches(regexp.MustCompile(`"id":\s*\d+`)), false},
+		{"matches/bad", CheckBodyMatches(regexp.MustCompile(`^\[`)), true},
+		{"json/null", CheckJSON("error", "null"), false},
+		{"json/number", CheckJSON("data.items.0.id", "42"), false},
+		{"json/string", CheckJSON("data.items.0.name", "goku"), false},
+		{"json/bad", CheckJSON("data.items.0.name", "vegeta"), true},
+		{"json/index", CheckJSON("data.items.1.name", "goku"), true},
+		{"json/missing", CheckJSON("data.items.0.name.first", "goku"), true},
+		{"latency", CheckLatency(time.Second), false},
+		{"latency/bad", CheckLatency(10 * time.Millisecond), true},
+	} {
+		if err := tc.check.Check(r, body, latency); (err != nil) != tc.fail {
+			t.Errorf("%s: want failure: %t, got: %v", tc.name, tc.fail, err)
+		}
+	}
+
+	if err := CheckJSON("error", "null").Check(r, []byte("<html>"), latency); err == nil {
+		t.Error("json/invalid: want failure, got none")
+	}
+}
@@ -0,0 +1,152 @@
+package vegeta
+
+import (
+	"bytes"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// formRequest creates an *http.Request whose body is made of the Target's
+// Form and Files. Without Files, it's URL encoded. Otherwise, it's a
+// multipart form whose files are streamed from disk.
+func (t *Target) formRequest() (*http.Request, error) {
+	if len(t.Files) == 0 {
+		req, err := http.NewRequest(t.Method, t.URL, strings.NewReader(t.Form.Encode()))
+		if err != nil {
+			return nil, err
+		}
+		t.setHeader(req)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		return req, nil
+	}
+
+	body, err := t.multipartBody()
+	if err != nil {
+		return nil, err
+	}
+	rc, err := body.open()
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(t.Method, t.URL, rc)
+	if err != nil {
+		rc.Close()
+		return nil, err
+	}
+	req.ContentLength = body.size
+	req.GetBody = body.open
+	t.setHeader(req)
+	req.Header.Set("Content-Type", body.contentType)
+	return req, nil
+}
+
+// multipartBody is a multipart form body made of in-memory segments, with the
+// form fields and part headers, interleaved with files read from disk.
+type multipartBody struct {
+	segments    []bodySegment
+	size        int64
+	contentType string
+}
+
+// bodySegment is either a chunk of data or the path of a file.
+type bodySegment struct {
+	data []byte
+	path string
+}
+
+// multipartBody lays out the multipart form body of the Target, with its
+// fields and files in order of their names. The size of each file is
+// taken up front so that the size of the whole body is known.
+func (t *Target) multipartBody() (*multipartBody, error) {
+	var (
+		buf  bytes.Buffer
+		body multipartBody
+		w    = multipart.NewWriter(&buf)
+	)
+	flush := func() {
+		data := append([]byte(nil), buf.Bytes()...)
+		body.segments = append(body.segments, bodySegment{data: data})
+		body.size += int64(len(data))
+		buf.Reset()
+	}
+
+	for _, name := range sortedKeys(t.Form) {
+		for _, v := range t.Form[name] {
+			if err := w.WriteField(name, v); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for _, field := range sortedKeys(t.Files) {
+		for _, path := range t.Files[field] {
+			fi, err := os.Stat(path)
+			if err != nil {
+				return nil, err
+			}
+			if _, err = w.CreateFormFile(field, filepath.Base(path)); err != nil {
+				return nil, err
+			}
+			flush()
+			body.segments = append(body.segments, bodySegment{path: path})
+			body.size += fi.Size()
+		}
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	flush()
+
+	body.contentType = w.FormDataContentType()
+	return &body, nil
+}
+
+// open returns a new io.ReadCloser of the whole body.
+func (b *multipartBody) open() (io.ReadCloser, error) {
+	var (
+		rs    = make([]io.Reader, 0, len(b.segments))
+		files multiCloser
+	)
+	for _, s := range b.segments {
+		if s.path == "" {
+			rs = append(rs, bytes.NewReader(s.data))
+			continue
+		}
+		f, err := os.Open(s.path)
+		if err != nil {
+			files.Close()
+			return nil, err
+		}
+		files = append(files, f)
+		rs = append(rs, f)
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(rs...), files}, nil
+}
+
+// multiCloser closes all of its files.
+type multiCloser []*os.File
+
+func (mc multiCloser) Close() (err error) {
+	for _, f := range mc {
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+func sortedKeys(m map[string][]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
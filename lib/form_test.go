@@ -0,0 +1,119 @@
+package vegeta
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTargetRequestForm(t *testing.T) {
+	t.Parallel()
+
+	tgt := Target{
+		Method: "POST",
+		URL:    "http://:9999/",
+		Body:   []byte("ignored"),
+		Form:   url.Values{"name": []string{"goku"}, "power": []string{"9001"}},
+	}
+	req, err := tgt.Request()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ct := req.Header.Get("Content-Type"); ct != "application/x-www-form-urlencoded" {
+		t.Errorf("Wrong Content-Type: %s", ct)
+	}
+	if err = req.ParseForm(); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(req.PostForm, tgt.Form) {
+		t.Errorf("want form %v, got %v", tgt.Form, req.PostForm)
+	}
+}
+
+func TestTargetRequestMultipartForm(t *testing.T) {
+	t.Parallel()
+
+	dir, err := ioutil.TempDir("", "vegeta-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{"avatar.png": "\x89PNG", "notes.txt": strings.Repeat("kamehameha\n", 1000)}
+	for name, data := range files {
+		if err := ioutil.WriteFile(dir+"/"+name, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	src := fmt.Sprintf(`
+		POST http://:9999/upload
+		Content-Type: application/json
+		form: name=goku
+		form: name=kakarot
+		file: avatar=@%s/avatar.png
+		file: docs=@%s/notes.txt
+	`, dir, dir)
+	tgt, err := NewLazyTargeter(strings.NewReader(strings.TrimSpace(src)), nil, nil)()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		req, err := tgt.Request()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i == 1 {
+			if req.Body, err = req.GetBody(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		body, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if int64(len(body)) != req.ContentLength {
+			t.Fatalf("want ContentLength %d, got %d", len(body), req.ContentLength)
+		}
+		req.Body = ioutil.NopCloser(strings.NewReader(string(body)))
+
+		if err = req.ParseMultipartForm(1 << 20); err != nil {
+			t.Fatal(err)
+		}
+		if want := []string{"goku", "kakarot"}; !reflect.DeepEqual(req.MultipartForm.Value["name"], want) {
+			t.Errorf("want name %v, got %v", want, req.MultipartForm.Value["name"])
+		}
+		for field, name := range map[string]string{"avatar": "avatar.png", "docs": "notes.txt"} {
+			fhs := req.MultipartForm.File[field]
+			if len(fhs) != 1 || fhs[0].Filename != name {
+				t.Fatalf("%s: want file %s, got %v", field, name, fhs)
+			}
+			f, _ := fhs[0].Open()
+			data, _ := ioutil.ReadAll(f)
+			f.Close()
+			if string(data) != files[name] {
+				t.Errorf("%s: wrong file contents: %q", field, data)
+			}
+		}
+	}
+}
+
+func TestFormDirectives(t *testing.T) {
+	t.Parallel()
+
+	for want, def := range map[string]string{
+		"bad form field": "POST http://:9999/\nform: novalue",
+		"bad form file":  "POST http://:9999/\nfile: avatar=/no/at/sign",
+		"bad form file ": "POST http://:9999/\nfile: avatar=@/non/existent",
+	} {
+		_, err := NewLazyTargeter(strings.NewReader(def), nil, nil)()
+		if err == nil || !strings.HasPrefix(err.Error(), strings.TrimSpace(want)) {
+			t.Errorf("got: %v, want: %s\n%s", err, want, def)
+		}
+	}
+}
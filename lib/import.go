@@ -0,0 +1,217 @@
+package vegeta
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// har is the subset of an HTTP Archive (HAR) needed to build Targets.
+// See http://www.softwareishard.com/blog/har-12-spec/
+type har struct {
+	Log struct {
+		Entries []struct {
+			StartedDateTime time.Time `json:"startedDateTime"`
+			Request         struct {
+				Method   string    `json:"method"`
+				URL      string    `json:"url"`
+				Headers  []harPair `json:"headers"`
+				PostData *struct {
+					MimeType string    `json:"mimeType"`
+					Text     string    `json:"text"`
+					Params   []harPair `json:"params"`
+				} `json:"postData"`
+			} `json:"request"`
+		} `json:"entries"`
+	} `json:"log"`
+}
+
+type harPair struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// NewHARTargeter returns a new Targeter that reads the HTTP Archive (HAR),
+// as exported by browsers, from the provided io.Reader on its first invocation
+// and then returns a Target for each of its requests, in order, on every
+// invocation. Their Offsets are set from the time they were started, relative
+// to the first one.
+//
+// Headers are preserved except for HTTP/2 pseudo-headers and Content-Length,
+// which is set from the body. Requests with form parameters but no body text
+// get a URL encoded body built from them.
+//
+// body will be set as the Target's body if no body is provided.
+// hdr will be merged with the each Target's headers.
+func NewHARTargeter(src io.Reader, body []byte, hdr http.Header) Targeter {
+	var (
+		mu    sync.Mutex
+		once  sync.Once
+		doc   har
+		err   error
+		next  int
+		began time.Time
+	)
+	return func() (*Target, error) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		once.Do(func() {
+			if err = json.NewDecoder(src).Decode(&doc); err != nil {
+				err = fmt.Errorf("bad HAR: %s", err)
+			}
+			for _, e := range doc.Log.Entries {
+				if began.IsZero() || e.StartedDateTime.Before(began) {
+					began = e.StartedDateTime
+				}
+			}
+		})
+		if err != nil {
+			return nil, err
+		} else if next >= len(doc.Log.Entries) {
+			return nil, ErrNoTargets
+		}
+
+		e := doc.Log.Entries[next]
+		r := e.Request
+		next++
+
+		if !httpMethodChecker.MatchString(r.Method) {
+			return nil, fmt.Errorf("bad method: %s", r.Method)
+		}
+		if _, err := url.ParseRequestURI(r.URL); err != nil {
+			return nil, fmt.Errorf("bad URL: %s", r.URL)
+		}
+
+		tgt := Target{Method: r.Method, URL: r.URL, Body: body, Header: http.Header{}}
+		if !e.StartedDateTime.IsZero() {
+			tgt.Offset = e.StartedDateTime.Sub(began)
+		}
+		for k, vs := range hdr {
+			tgt.Header[k] = append([]string(nil), vs...)
+		}
+		for _, h := range r.Headers {
+			if strings.HasPrefix(h.Name, ":") || strings.EqualFold(h.Name, "Content-Length") {
+				continue
+			}
+			tgt.Header.Add(h.Name, h.Value)
+		}
+		if pd := r.PostData; pd != nil {
+			if pd.Text != "" {
+				tgt.Body = []byte(pd.Text)
+			} else if len(pd.Params) > 0 {
+				form := url.Values{}
+				for _, p := range pd.Params {
+					form.Add(p.Name, p.Value)
+				}
+				tgt.Body = []byte(form.Encode())
+			}
+			if pd.MimeType != "" && tgt.Header.Get("Content-Type") == "" {
+				tgt.Header.Set("Content-Type", pd.MimeType)
+			}
+		}
+		return &tgt, nil
+	}
+}
+
+// accessLogLine matches Common and Combined Log Format lines, e.g.
+//
+//	127.0.0.1 - frank [10/Oct/2000:13:55:36 -0700] "GET /apache_pb.gif HTTP/1.0" 200 2326 "http://www.example.com/start.html" "Mozilla/4.08"
+var accessLogLine = regexp.MustCompile(
+	`^\S+ \S+ \S+ \[([^\]]+)\] "((?:[^"\\]|\\.)*)" \S+ \S+(?: "((?:[^"\\]|\\.)*)" "((?:[^"\\]|\\.)*)")?`,
+)
+
+// accessLogTime is the time layout of access log lines.
+const accessLogTime = "02/Jan/2006:15:04:05 -0700"
+
+// MaxAccessLogLine is the maximum length of the access log lines read by
+// NewAccessLogTargeter, which can be long with big query strings.
+const MaxAccessLogLine = 1024 * 1024
+
+// NewAccessLogTargeter returns a new Targeter that lazily scans Targets from
+// the Common or Combined Log Format access log read from the provided
+// io.Reader on every invocation. Their Offsets are set from the time they were
+// logged, relative to the first one. Request paths are resolved against base,
+// e.g. http://goku:9090, which can only be empty if they're absolute URLs.
+// Entries without a request, logged as "-", are skipped. Lines can be up to
+// MaxAccessLogLine bytes long.
+//
+// The Referer and User-Agent headers of Combined Log Format lines are
+// preserved.
+//
+// body will be set as the Target's body.
+// hdr will be merged with the each Target's headers.
+func NewAccessLogTargeter(src io.Reader, base string, body []byte, hdr http.Header) Targeter {
+	var (
+		mu    sync.Mutex
+		began time.Time
+		lines int
+	)
+	sc := bufio.NewScanner(src)
+	sc.Buffer(nil, MaxAccessLogLine)
+	base = strings.TrimSuffix(base, "/")
+	return func() (*Target, error) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		var m []string
+		for m == nil {
+			if !sc.Scan() {
+				if err := sc.Err(); err != nil {
+					return nil, fmt.Errorf("bad log: %s (line %d)", err, lines+1)
+				}
+				return nil, ErrNoTargets
+			}
+			lines++
+			line := strings.TrimSpace(sc.Text())
+			if line == "" {
+				continue
+			} else if m = accessLogLine.FindStringSubmatch(line); m == nil {
+				return nil, fmt.Errorf("bad log line: %s", line)
+			} else if m[2] == "-" {
+				m = nil
+			}
+		}
+
+		tokens := strings.Fields(m[2])
+		if len(tokens) < 2 {
+			return nil, fmt.Errorf("bad request: %s", m[2])
+		} else if !httpMethodChecker.MatchString(tokens[0]) {
+			return nil, fmt.Errorf("bad method: %s", tokens[0])
+		}
+
+		u := tokens[1]
+		if strings.HasPrefix(u, "/") {
+			u = base + u
+		}
+		if parsed, err := url.ParseRequestURI(u); err != nil || parsed.Host == "" {
+			return nil, fmt.Errorf("bad URL: %s", u)
+		}
+
+		at, err := time.Parse(accessLogTime, m[1])
+		if err != nil {
+			return nil, fmt.Errorf("bad time: %s", m[1])
+		} else if began.IsZero() {
+			began = at
+		}
+
+		tgt := Target{Method: tokens[0], URL: u, Body: body, Header: http.Header{}, Offset: at.Sub(began)}
+		for k, vs := range hdr {
+			tgt.Header[k] = append([]string(nil), vs...)
+		}
+		if referer := m[3]; referer != "" && referer != "-" {
+			tgt.Header.Set("Referer", referer)
+		}
+		if agent := m[4]; agent != "" && agent != "-" {
+			tgt.Header.Set("User-Agent", agent)
+		}
+		return &tgt, nil
+	}
+}
@@ -0,0 +1,144 @@
+package vegeta
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewHARTargeter(t *testing.T) {
+	t.Parallel()
+
+	for want, def := range map[string]string{
+		"bad HAR":    `{"log": {"entries": [`,
+		"bad method": `{"log": {"entries": [{"request": {"method": "", "url": "http://:6060/"}}]}}`,
+		"bad URL":    `{"log": {"entries": [{"request": {"method": "GET", "url": "foobar"}}]}}`,
+	} {
+		read := NewHARTargeter(strings.NewReader(def), nil, nil)
+		if _, got := read(); got == nil || !strings.HasPrefix(got.Error(), want) {
+			t.Errorf("got: %s, want: %s\n%s", got, want, def)
+		}
+	}
+
+	src := `{"log": {"version": "1.2", "entries": [
+		{"startedDateTime": "2017-01-02T15:04:05.500Z", "request": {
+			"method": "GET", "url": "http://:6060/things?id=1", "httpVersion": "HTTP/2.0",
+			"headers": [{"name": ":authority", "value": "goku"}, {"name": "Accept", "value": "*/*"}]
+		}},
+		{"startedDateTime": "2017-01-02T15:04:05.000Z", "request": {
+			"method": "POST", "url": "http://:6060/things", "httpVersion": "HTTP/1.1",
+			"headers": [{"name": "Content-Length", "value": "11"}],
+			"postData": {"mimeType": "application/json", "text": "{\"id\": \"1\"}"}
+		}},
+		{"startedDateTime": "2017-01-02T15:04:07.000Z", "request": {
+			"method": "POST", "url": "http://:6060/login", "httpVersion": "HTTP/1.1",
+			"headers": [{"name": "Content-Type", "value": "application/x-www-form-urlencoded"}],
+			"postData": {"mimeType": "application/x-www-form-urlencoded", "params": [{"name": "user", "value": "goku"}]}
+		}}
+	]}}`
+
+	read := NewHARTargeter(strings.NewReader(src), []byte("default"), http.Header{"X-Default": []string{"1"}})
+	for _, want := range []*Target{
+		{
+			Method: "GET",
+			URL:    "http://:6060/things?id=1",
+			Body:   []byte("default"),
+			Header: http.Header{"X-Default": []string{"1"}, "Accept": []string{"*/*"}},
+			Offset: 500 * time.Millisecond,
+		},
+		{
+			Method: "POST",
+			URL:    "http://:6060/things",
+			Body:   []byte(`{"id": "1"}`),
+			Header: http.Header{"X-Default": []string{"1"}, "Content-Type": []string{"application/json"}},
+		},
+		{
+			Method: "POST",
+			URL:    "http://:6060/login",
+			Body:   []byte("user=goku"),
+			Header: http.Header{"X-Default": []string{"1"}, "Content-Type": []string{"application/x-www-form-urlencoded"}},
+			Offset: 2 * time.Second,
+		},
+	} {
+		if got, err := read(); err != nil {
+			t.Fatal(err)
+		} else if !reflect.DeepEqual(want, got) {
+			t.Fatalf("want: %#v, got: %#v", want, got)
+		}
+	}
+	if _, err := read(); err != ErrNoTargets {
+		t.Fatalf("got: %v, want: %v", err, ErrNoTargets)
+	}
+}
+
+func TestNewAccessLogTargeter(t *testing.T) {
+	t.Parallel()
+
+	for want, def := range map[string]string{
+		"bad log line": `127.0.0.1 - - "GET / HTTP/1.1" 200 2`,
+		"bad request":  `127.0.0.1 - - [10/Oct/2000:13:55:36 -0700] "\x16\x03\x01" 400 0`,
+		"bad method":   `127.0.0.1 - - [10/Oct/2000:13:55:36 -0700] "G(E)T / HTTP/1.1" 200 2`,
+		"bad URL":      `127.0.0.1 - - [10/Oct/2000:13:55:36 -0700] "GET foobar HTTP/1.1" 200 2`,
+		"bad time":     `127.0.0.1 - - [10/10/2000 13:55:36] "GET / HTTP/1.1" 200 2`,
+	} {
+		read := NewAccessLogTargeter(strings.NewReader(def), "http://:6060", nil, nil)
+		if _, got := read(); got == nil || !strings.HasPrefix(got.Error(), want) {
+			t.Errorf("got: %s, want: %s\n%s", got, want, def)
+		}
+	}
+
+	// Long lines are read up to MaxAccessLogLine bytes, and longer ones
+	// are reported instead of ending the log.
+	long := `127.0.0.1 - - [10/Oct/2000:13:55:36 -0700] "GET /?q=` + strings.Repeat("x", 70*1024) + ` HTTP/1.1" 200 2`
+	read := NewAccessLogTargeter(strings.NewReader(long+"\n"+strings.Repeat("x", MaxAccessLogLine)), "http://:6060", nil, nil)
+	if tgt, err := read(); err != nil || len(tgt.URL) < 70*1024 {
+		t.Fatalf("want a long line read, got error %v", err)
+	}
+	want := "bad log: bufio.Scanner: token too long (line 2)"
+	if _, err := read(); err == nil || err.Error() != want {
+		t.Fatalf("want error %q, got %v", want, err)
+	}
+
+	src := `
+		127.0.0.1 - frank [10/Oct/2000:13:55:36 -0700] "GET /apache_pb.gif HTTP/1.0" 200 2326
+		127.0.0.1 - - [10/Oct/2000:13:55:37 -0700] "-" 408 0
+
+		127.0.0.1 - - [10/Oct/2000:13:55:38 -0700] "POST /things?id=1 HTTP/1.1" 201 0 "http://goku/start.html" "Mozilla/4.08 [en] (Win98; I ;Nav)"
+		127.0.0.1 - - [10/Oct/2000:13:55:39 -0700] "GET http://vegeta:7070/proxied HTTP/1.1" 200 2 "-" "curl/7.54.0"
+	`
+	read = NewAccessLogTargeter(strings.NewReader(src), "http://:6060/", nil, http.Header{"X-Default": []string{"1"}})
+	for _, want := range []*Target{
+		{
+			Method: "GET",
+			URL:    "http://:6060/apache_pb.gif",
+			Header: http.Header{"X-Default": []string{"1"}},
+		},
+		{
+			Method: "POST",
+			URL:    "http://:6060/things?id=1",
+			Header: http.Header{
+				"X-Default":  []string{"1"},
+				"Referer":    []string{"http://goku/start.html"},
+				"User-Agent": []string{"Mozilla/4.08 [en] (Win98; I ;Nav)"},
+			},
+			Offset: 2 * time.Second,
+		},
+		{
+			Method: "GET",
+			URL:    "http://vegeta:7070/proxied",
+			Header: http.Header{"X-Default": []string{"1"}, "User-Agent": []string{"curl/7.54.0"}},
+			Offset: 3 * time.Second,
+		},
+	} {
+		if got, err := read(); err != nil {
+			t.Fatal(err)
+		} else if !reflect.DeepEqual(want, got) {
+			t.Fatalf("want: %#v, got: %#v", want, got)
+		}
+	}
+	if _, err := read(); err != ErrNoTargets {
+		t.Fatalf("got: %v, want: %v", err, ErrNoTargets)
+	}
+}
@@ -1,22 +1,34 @@
 package vegeta
 
 import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
 	"strconv"
 	"time"
-
-	"github.com/bmizerany/perks/quantile"
 )
 
-// Metrics holds the stats computed out of a slice of Results
+// MaxErrors is the maximum number of unique errors kept by Metrics. Errors
+// first occurring after that many others, like ones with ephemeral ports in
+// them, aren't kept.
+var MaxErrors = 1000
+
+// Metrics holds the stats computed out of a slice or a stream of Results
 // that is used for some of the Reporters
 type Metrics struct {
-	Latencies struct {
-		Mean time.Duration `json:"mean"`
-		P50  time.Duration `json:"50th"` // P50 is the 50th percentile upper value
-		P95  time.Duration `json:"95th"` // P95 is the 95th percentile upper value
-		P99  time.Duration `json:"99th"` // P99 is the 99th percentile upper value
-		Max  time.Duration `json:"max"`
-	} `json:"latencies"`
+	Latencies LatencyMetrics `json:"latencies"`
+
+	// Phases holds the latencies of each phase of the requests. DNS, Connect
+	// and TLS only account for the requests which went through them while
+	// FirstByte and BodyRead only account for the ones which got a response.
+	Phases struct {
+		DNS       LatencyMetrics `json:"dns"`
+		Connect   LatencyMetrics `json:"connect"`
+		TLS       LatencyMetrics `json:"tls"`
+		FirstByte LatencyMetrics `json:"first_byte"`
+		BodyRead  LatencyMetrics `json:"body_read"`
+	} `json:"phases"`
 
 	BytesIn struct {
 		Total uint64  `json:"total"`
@@ -34,65 +46,292 @@ type Metrics struct {
 	Wait time.Duration `json:"wait"`
 	// Requests is the total number of requests executed.
 	Requests uint64 `json:"requests"`
-	// Success is the percentage of non-error responses.
+	// Rate is the rate of requests sent per second, over the duration of
+	// the attack.
+	Rate float64 `json:"rate"`
+	// Throughput is the rate of successful responses per second, over the
+	// whole attack including the wait for its last responses.
+	Throughput float64 `json:"throughput"`
+	// Success is the percentage of successful hits, see Result.Success.
 	Success float64 `json:"success"`
+	// Reused is the percentage of requests which reused a connection.
+	Reused float64 `json:"reused"`
 	// StatusCodes is a histogram of the responses' status codes.
 	StatusCodes map[string]int `json:"status_codes"`
-	// Errors is a set of unique errors returned by the targets during the attack.
+	// Errors is a set of unique errors returned by the targets during the
+	// attack, in the order they first occurred, up to MaxErrors of them.
 	Errors []string `json:"errors"`
+	// Engine is the name of the PercentileEngine which computed the latency
+	// percentiles.
+	Engine string `json:"percentile_engine"`
+
+	percentiles         []float64
+	engine              PercentileEngine
+	errors              map[string]struct{}
+	success, reused     uint64
+	first, last, latest time.Time
 }
 
-// NewMetrics computes and returns a Metrics struct out of a slice of Results.
-func NewMetrics(r Results) *Metrics {
-	m := &Metrics{StatusCodes: map[string]int{}}
+// LatencyMetrics holds the stats computed out of a set of latencies.
+type LatencyMetrics struct {
+	Min  time.Duration `json:"min"`
+	Mean time.Duration `json:"mean"`
+	P50  time.Duration `json:"50th"` // P50 is the 50th percentile upper value
+	P95  time.Duration `json:"95th"` // P95 is the 95th percentile upper value
+	P99  time.Duration `json:"99th"` // P99 is the 99th percentile upper value
+	Max  time.Duration `json:"max"`
+	// StdDev is the population standard deviation of the latencies.
+	StdDev time.Duration `json:"stddev"`
+	// Percentiles holds the configured percentiles, which are
+	// DefaultPercentiles unless set otherwise with the Percentiles option.
+	Percentiles LatencyPercentiles `json:"percentiles"`
 
-	if len(r) == 0 {
-		return m
+	total  time.Duration
+	count  uint64
+	mean   float64 // running mean and sum of squared deviations from it,
+	m2     float64 // updated with Welford's algorithm
+	quants estimator
+	ps     []float64
+	engine PercentileEngine
+}
+
+// DefaultPercentiles are the latency percentiles computed by default.
+var DefaultPercentiles = []float64{50, 95, 99}
+
+// add adds the given latency to the set.
+func (l *LatencyMetrics) add(latency time.Duration) {
+	if l.quants == nil {
+		qs := []float64{0.50, 0.95, 0.99}
+		for _, p := range l.percentiles() {
+			qs = append(qs, p/100)
+		}
+		l.quants = l.engine.estimator(qs)
 	}
+	l.quants.Insert(float64(latency))
+	l.total += latency
+	l.count++
+	delta := float64(latency) - l.mean
+	l.mean += delta / float64(l.count)
+	l.m2 += delta * (float64(latency) - l.mean)
+	if latency > l.Max {
+		l.Max = latency
+	}
+	if latency < l.Min || l.count == 1 {
+		l.Min = latency
+	}
+}
 
-	var (
-		errorSet       = map[string]struct{}{}
-		quants         = quantile.NewTargeted(0.50, 0.95, 0.99)
-		totalSuccess   int
-		totalLatencies time.Duration
-		latest         time.Time
-	)
+// compute computes the stats out of the added latencies.
+func (l *LatencyMetrics) compute() {
+	ps := l.percentiles()
+	l.Percentiles = make(LatencyPercentiles, len(ps))
+	for i, p := range ps {
+		l.Percentiles[i].Percent = p
+	}
+	if l.count == 0 {
+		return
+	}
+	l.Mean = time.Duration(float64(l.total) / float64(l.count))
+	l.StdDev = time.Duration(math.Sqrt(l.m2 / float64(l.count)))
+	l.P50 = time.Duration(l.quants.Query(0.50))
+	l.P95 = time.Duration(l.quants.Query(0.95))
+	l.P99 = time.Duration(l.quants.Query(0.99))
+	for i := range l.Percentiles {
+		l.Percentiles[i].Latency = time.Duration(l.quants.Query(ps[i] / 100))
+	}
+}
 
-	for _, result := range r {
-		quants.Insert(float64(result.Latency))
-		m.StatusCodes[strconv.Itoa(int(result.Code))]++
-		totalLatencies += result.Latency
-		m.BytesOut.Total += result.BytesOut
-		m.BytesIn.Total += result.BytesIn
-		if result.Latency > m.Latencies.Max {
-			m.Latencies.Max = result.Latency
+func (l *LatencyMetrics) percentiles() []float64 {
+	if l.ps == nil {
+		return DefaultPercentiles
+	}
+	return l.ps
+}
+
+// Percentile is the latency at or below which the given Percent of the latencies
+// of a set fall.
+type Percentile struct {
+	Percent float64
+	Latency time.Duration
+}
+
+// LatencyPercentiles is a list of Percentiles, in the order they were
+// asked for.
+type LatencyPercentiles []Percentile
+
+// Get returns the latency of the given percentile, or zero if it wasn't
+// computed.
+func (ps LatencyPercentiles) Get(percent float64) time.Duration {
+	for _, p := range ps {
+		if p.Percent == percent {
+			return p.Latency
 		}
-		if end := result.Timestamp.Add(result.Latency); end.After(latest) {
-			latest = end
+	}
+	return 0
+}
+
+// MarshalJSON implements the json.Marshaler interface. Percentiles are
+// encoded as an object with their latencies keyed by percentage, like
+// {"50": 1000000, "99.9": 2000000}, in order.
+func (ps LatencyPercentiles) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, p := range ps {
+		if i > 0 {
+			buf.WriteByte(',')
 		}
-		if result.Code >= 200 && result.Code < 300 {
-			totalSuccess++
+		fmt.Fprintf(&buf, "%q:%d", strconv.FormatFloat(p.Percent, 'f', -1, 64), int64(p.Latency))
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface.
+func (ps *LatencyPercentiles) UnmarshalJSON(data []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if _, err := dec.Token(); err != nil {
+		return err
+	}
+	*ps = (*ps)[:0]
+	for dec.More() {
+		key, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		percent, err := strconv.ParseFloat(key.(string), 64)
+		if err != nil {
+			return fmt.Errorf("bad percentile: %s", key)
 		}
-		if result.Error != "" {
-			errorSet[result.Error] = struct{}{}
+		var latency time.Duration
+		if err = dec.Decode(&latency); err != nil {
+			return err
 		}
+		*ps = append(*ps, Percentile{Percent: percent, Latency: latency})
+	}
+	return nil
+}
+
+// Percentiles returns an option for NewMetrics which sets the latency
+// percentiles to compute, as percentages like 99.9, instead of
+// DefaultPercentiles.
+func Percentiles(ps ...float64) func(*Metrics) {
+	return func(m *Metrics) { m.percentiles = ps }
+}
+
+// NewMetrics computes and returns a Metrics struct out of a slice of Results,
+// configured with the given options. More Results can be added to it
+// afterwards, e.g. to compute Metrics with options out of a stream of Results
+// starting from NewMetrics(nil, opts...).
+func NewMetrics(r Results, opts ...func(*Metrics)) *Metrics {
+	var m Metrics
+	for _, opt := range opts {
+		opt(&m)
 	}
+	for _, result := range r {
+		m.Add(result)
+	}
+	m.Close()
+	return &m
+}
+
+// Add adds the given Result to the Metrics, whose stats are computed on Close.
+// Results can be added in any order, so the zero Metrics is ready to compute
+// stats out of a stream of Results. With the approx and HDR engines, only a
+// bounded amount of memory is used regardless of how many are added, while
+// ExactPercentiles keeps every latency.
+func (m *Metrics) Add(r *Result) {
+	m.init()
 
-	m.Requests = uint64(len(r))
-	m.Duration = r[len(r)-1].Timestamp.Sub(r[0].Timestamp)
-	m.Wait = latest.Sub(r[len(r)-1].Timestamp)
-	m.Latencies.Mean = time.Duration(float64(totalLatencies) / float64(m.Requests))
-	m.Latencies.P50 = time.Duration(quants.Query(0.50))
-	m.Latencies.P95 = time.Duration(quants.Query(0.95))
-	m.Latencies.P99 = time.Duration(quants.Query(0.99))
+	m.Requests++
+	m.Latencies.add(r.Latency)
+	m.StatusCodes[strconv.Itoa(int(r.Code))]++
+	m.BytesOut.Total += r.BytesOut
+	m.BytesIn.Total += r.BytesIn
+	if r.DNS > 0 {
+		m.Phases.DNS.add(r.DNS)
+	}
+	if r.Connect > 0 {
+		m.Phases.Connect.add(r.Connect)
+	}
+	if r.TLS > 0 {
+		m.Phases.TLS.add(r.TLS)
+	}
+	if r.Code != 0 {
+		m.Phases.FirstByte.add(r.FirstByte)
+		m.Phases.BodyRead.add(r.BodyRead)
+	}
+	if r.Reused {
+		m.reused++
+	}
+	if m.first.IsZero() || r.Timestamp.Before(m.first) {
+		m.first = r.Timestamp
+	}
+	if r.Timestamp.After(m.last) {
+		m.last = r.Timestamp
+	}
+	if end := r.Timestamp.Add(r.Latency); end.After(m.latest) {
+		m.latest = end
+	}
+	if r.Success() {
+		m.success++
+	}
+	if _, ok := m.errors[r.Error]; !ok && r.Error != "" && len(m.Errors) < MaxErrors {
+		m.errors[r.Error] = struct{}{}
+		m.Errors = append(m.Errors, r.Error)
+	}
+}
+
+// Close computes the stats out of all the Results added so far. More Results
+// can still be added afterwards, as long as Close is called again.
+func (m *Metrics) Close() {
+	m.init()
+	m.Engine = m.engine.String()
+	for _, l := range m.latencies() {
+		l.compute()
+	}
+	if m.Requests == 0 {
+		return
+	}
+
+	m.Duration = m.last.Sub(m.first)
+	m.Wait = m.latest.Sub(m.last)
 	m.BytesIn.Mean = float64(m.BytesIn.Total) / float64(m.Requests)
 	m.BytesOut.Mean = float64(m.BytesOut.Total) / float64(m.Requests)
-	m.Success = float64(totalSuccess) / float64(m.Requests)
+	m.Success = float64(m.success) / float64(m.Requests)
+	m.Reused = float64(m.reused) / float64(m.Requests)
+	if m.Duration > 0 {
+		m.Rate = float64(m.Requests) / m.Duration.Seconds()
+	}
+	if total := m.Duration + m.Wait; total > 0 {
+		m.Throughput = float64(m.success) / total.Seconds()
+	}
+	if m.Errors == nil {
+		m.Errors = []string{}
+	}
+}
 
-	m.Errors = make([]string, 0, len(errorSet))
-	for err := range errorSet {
-		m.Errors = append(m.Errors, err)
+// init prepares the Metrics to have Results added, once.
+func (m *Metrics) init() {
+	if m.errors != nil {
+		return
+	}
+	m.errors = map[string]struct{}{}
+	if m.StatusCodes == nil {
+		m.StatusCodes = map[string]int{}
 	}
+	for _, l := range m.latencies() {
+		l.ps, l.engine = m.percentiles, m.engine
+	}
+}
 
-	return m
+// latencies returns all the LatencyMetrics of the Metrics.
+func (m *Metrics) latencies() []*LatencyMetrics {
+	return []*LatencyMetrics{
+		&m.Latencies,
+		&m.Phases.DNS,
+		&m.Phases.Connect,
+		&m.Phases.TLS,
+		&m.Phases.FirstByte,
+		&m.Phases.BodyRead,
+	}
 }
@@ -1,6 +1,10 @@
 package vegeta
 
 import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
 	"testing"
 	"time"
 )
@@ -9,15 +13,21 @@ func TestNewMetrics(t *testing.T) {
 	t.Parallel()
 
 	m := NewMetrics(Results{
-		&Result{500, time.Unix(0, 0), 100 * time.Millisecond, 10, 30, "Internal server error"},
-		&Result{200, time.Unix(1, 0), 20 * time.Millisecond, 20, 20, ""},
-		&Result{200, time.Unix(2, 0), 30 * time.Millisecond, 30, 10, ""},
+		&Result{Code: 500, Timestamp: time.Unix(0, 0), Latency: 100 * time.Millisecond, BytesOut: 10, BytesIn: 30, Error: "Internal server error",
+			DNS: 10 * time.Millisecond, Connect: 20 * time.Millisecond, FirstByte: 60 * time.Millisecond, BodyRead: 10 * time.Millisecond},
+		&Result{Code: 200, Timestamp: time.Unix(1, 0), Latency: 20 * time.Millisecond, BytesOut: 20, BytesIn: 20,
+			FirstByte: 15 * time.Millisecond, BodyRead: 5 * time.Millisecond, Reused: true},
+		&Result{Code: 200, Timestamp: time.Unix(2, 0), Latency: 30 * time.Millisecond, BytesOut: 30, BytesIn: 10,
+			FirstByte: 24 * time.Millisecond, BodyRead: 6 * time.Millisecond, Reused: true},
 	})
 
 	for field, values := range map[string][]float64{
 		"BytesIn.Mean":  []float64{m.BytesIn.Mean, 20.0},
 		"BytesOut.Mean": []float64{m.BytesOut.Mean, 20.0},
 		"Sucess":        []float64{m.Success, 0.6666666666666666},
+		"Reused":        []float64{m.Reused, 0.6666666666666666},
+		"Throughput":    []float64{m.Throughput, 2 / (2030 * time.Millisecond).Seconds()},
+		"Rate":          []float64{m.Rate, 1.5},
 	} {
 		if values[0] != values[1] {
 			t.Errorf("%s: want: %f, got: %f", field, values[1], values[0])
@@ -25,13 +35,22 @@ func TestNewMetrics(t *testing.T) {
 	}
 
 	for field, values := range map[string][]time.Duration{
-		"Latencies.Max":  []time.Duration{m.Latencies.Max, 100 * time.Millisecond},
-		"Latencies.Mean": []time.Duration{m.Latencies.Mean, 50 * time.Millisecond},
-		"Latencies.P50":  []time.Duration{m.Latencies.P50, 20 * time.Millisecond},
-		"Latencies.P95":  []time.Duration{m.Latencies.P95, 30 * time.Millisecond},
-		"Latencies.P99":  []time.Duration{m.Latencies.P99, 30 * time.Millisecond},
-		"Duration":       []time.Duration{m.Duration, 2 * time.Second},
-		"Wait":           []time.Duration{m.Wait, 30 * time.Millisecond},
+		"Latencies.Min":         []time.Duration{m.Latencies.Min, 20 * time.Millisecond},
+		"Latencies.Max":         []time.Duration{m.Latencies.Max, 100 * time.Millisecond},
+		"Latencies.StdDev":      []time.Duration{m.Latencies.StdDev, 35590260 * time.Nanosecond},
+		"Phases.BodyRead.Min":   []time.Duration{m.Phases.BodyRead.Min, 5 * time.Millisecond},
+		"Latencies.Mean":        []time.Duration{m.Latencies.Mean, 50 * time.Millisecond},
+		"Latencies.P50":         []time.Duration{m.Latencies.P50, 20 * time.Millisecond},
+		"Latencies.P95":         []time.Duration{m.Latencies.P95, 30 * time.Millisecond},
+		"Latencies.P99":         []time.Duration{m.Latencies.P99, 30 * time.Millisecond},
+		"Phases.DNS.Max":        []time.Duration{m.Phases.DNS.Max, 10 * time.Millisecond},
+		"Phases.Connect.Mean":   []time.Duration{m.Phases.Connect.Mean, 20 * time.Millisecond},
+		"Phases.TLS.Mean":       []time.Duration{m.Phases.TLS.Mean, 0},
+		"Phases.FirstByte.Mean": []time.Duration{m.Phases.FirstByte.Mean, 33 * time.Millisecond},
+		"Phases.FirstByte.Max":  []time.Duration{m.Phases.FirstByte.Max, 60 * time.Millisecond},
+		"Phases.BodyRead.Mean":  []time.Duration{m.Phases.BodyRead.Mean, 7 * time.Millisecond},
+		"Duration":              []time.Duration{m.Duration, 2 * time.Second},
+		"Wait":                  []time.Duration{m.Wait, 30 * time.Millisecond},
 	} {
 		if values[0] != values[1] {
 			t.Errorf("%s: want: %s, got: %s", field, values[1], values[0])
@@ -61,3 +80,114 @@ func TestNewMetrics(t *testing.T) {
 func TestNewMetricsEmptyResults(t *testing.T) {
 	_ = NewMetrics(Results{}) // Must not panic
 }
+
+func TestMetricsMaxErrors(t *testing.T) {
+	t.Parallel()
+
+	var m Metrics
+	for i := 0; i < MaxErrors+10; i++ {
+		m.Add(&Result{Timestamp: time.Unix(0, 0), Error: fmt.Sprintf("dial tcp 127.0.0.1:%d: connection refused", i)})
+	}
+	m.Close()
+	if len(m.Errors) != MaxErrors || m.Errors[0] != "dial tcp 127.0.0.1:0: connection refused" {
+		t.Errorf("want the first %d errors, got %d starting with %q", MaxErrors, len(m.Errors), m.Errors[0])
+	}
+}
+
+func TestMetricsAdd(t *testing.T) {
+	t.Parallel()
+
+	results := make(Results, 100)
+	for i := range results {
+		results[i] = &Result{
+			Code:      200,
+			Timestamp: time.Unix(int64(i), 0),
+			Latency:   time.Duration(i+1) * time.Millisecond,
+			BytesIn:   uint64(i),
+		}
+		if i%10 == 0 {
+			results[i].Code, results[i].Error = 500, "Internal server error"
+		}
+	}
+	want := NewMetrics(results)
+
+	// Results stream in out of order and stats can be computed half way.
+	var m Metrics
+	for i := len(results) - 1; i >= 50; i-- {
+		m.Add(results[i])
+	}
+	m.Close()
+	if m.Requests != 50 || m.Duration != 49*time.Second {
+		t.Errorf("Snapshot: want 50 requests over 49s, got %d over %s", m.Requests, m.Duration)
+	}
+	for i := 49; i >= 0; i-- {
+		m.Add(results[i])
+	}
+	m.Close()
+
+	if !reflect.DeepEqual(m.Errors, want.Errors) || !reflect.DeepEqual(m.StatusCodes, want.StatusCodes) {
+		t.Errorf("want errors %v and codes %v, got %v and %v", want.Errors, want.StatusCodes, m.Errors, m.StatusCodes)
+	}
+	for field, values := range map[string][]interface{}{
+		"Requests":       {m.Requests, want.Requests},
+		"Duration":       {m.Duration, want.Duration},
+		"Wait":           {m.Wait, want.Wait},
+		"Success":        {m.Success, want.Success},
+		"Throughput":     {m.Throughput, want.Throughput},
+		"BytesIn":        {m.BytesIn, want.BytesIn},
+		"Latencies.Mean": {m.Latencies.Mean, want.Latencies.Mean},
+		"Latencies.Max":  {m.Latencies.Max, want.Latencies.Max},
+		"Latencies.Min":  {m.Latencies.Min, want.Latencies.Min},
+		"Rate":           {m.Rate, want.Rate},
+	} {
+		if values[0] != values[1] {
+			t.Errorf("%s: want: %v, got: %v", field, values[1], values[0])
+		}
+	}
+}
+
+func TestMetricsPercentiles(t *testing.T) {
+	t.Parallel()
+
+	results := make(Results, 1000)
+	for i := range results {
+		results[i] = &Result{Timestamp: time.Unix(int64(i), 0), Latency: time.Duration(i+1) * time.Millisecond}
+	}
+
+	m := NewMetrics(results, Percentiles(90, 99.9), Engine(ExactPercentiles))
+	if got := len(m.Latencies.Percentiles); got != 2 {
+		t.Fatalf("want 2 percentiles, got %d", got)
+	}
+	for _, p := range []struct {
+		percent float64
+		want    time.Duration
+	}{
+		{90, 900 * time.Millisecond},
+		{99.9, 999 * time.Millisecond},
+	} {
+		if got := m.Latencies.Percentiles.Get(p.percent); got != p.want {
+			t.Errorf("p%v: want %s, got %s", p.percent, p.want, got)
+		}
+	}
+	if m.Latencies.P50 == 0 || m.Phases.DNS.Percentiles[1].Percent != 99.9 {
+		t.Errorf("want P50 and phase percentiles to be computed, got %+v", m.Latencies)
+	}
+
+	if ps := NewMetrics(nil).Latencies.Percentiles; len(ps) != len(DefaultPercentiles) {
+		t.Errorf("want default percentiles for no results, got %v", ps)
+	}
+
+	data, err := json.Marshal(m.Latencies.Percentiles)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"90":`; !strings.HasPrefix(string(data), want) || !strings.Contains(string(data), `"99.9":`) {
+		t.Errorf("want percentiles keyed by percentage in order, got %s", data)
+	}
+	var ps LatencyPercentiles
+	if err = json.Unmarshal(data, &ps); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(ps, m.Latencies.Percentiles) {
+		t.Errorf("want %v, got %v", m.Latencies.Percentiles, ps)
+	}
+}
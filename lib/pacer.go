@@ -0,0 +1,275 @@
+package vegeta
+
+import (
+	"fmt"
+	"math"
+	"math/bits"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A Pacer defines the rate of hits during an Attack. Given the time elapsed
+// since the attack began and the number of hits sent so far, it returns how
+// long to wait until the next hit is due. A negative wait means the next hit
+// is already overdue by that amount. If stop is true, the attack is
+// terminated.
+type Pacer interface {
+	Pace(elapsed time.Duration, hits uint64) (wait time.Duration, stop bool)
+}
+
+// PacerFunc is an adapter to allow the use of ordinary functions as Pacers.
+type PacerFunc func(time.Duration, uint64) (time.Duration, bool)
+
+// Pace implements the Pacer interface.
+func (pf PacerFunc) Pace(elapsed time.Duration, hits uint64) (time.Duration, bool) {
+	return pf(elapsed, hits)
+}
+
+// Rate is a rational frequency of Freq hits every Per duration, e.g. 5 hits
+// every minute. A zero Per means per second. Rate is a Pacer which hits at
+// that constant frequency, starting right away.
+//
+// A zero Freq means there's no rate limit at all: an Attacker then uses a
+// fixed pool of workers which hit again as soon as their previous hit
+// completes, driving as much throughput as the target allows.
+type Rate struct {
+	Freq uint64
+	Per  time.Duration
+}
+
+// Pace implements the Pacer interface. The nth hit is due exactly at
+// n*Per/Freq, without accumulating rounding errors over time.
+func (r Rate) Pace(elapsed time.Duration, hits uint64) (time.Duration, bool) {
+	per := r.per()
+	if per < 0 {
+		return 0, true
+	} else if r.Freq == 0 {
+		return 0, false
+	}
+	hi, lo := bits.Mul64(hits, uint64(per))
+	if hi >= r.Freq {
+		return 0, true
+	}
+	at, _ := bits.Div64(hi, lo, r.Freq)
+	if at > math.MaxInt64 {
+		return 0, true
+	}
+	return time.Duration(at) - elapsed, false
+}
+
+// PerSecond returns the Rate as a number of hits per second.
+func (r Rate) PerSecond() float64 {
+	return float64(r.Freq) / r.per().Seconds()
+}
+
+// Set implements the flag.Value interface. It parses rates in the N/duration
+// form, like 5/1m, 300/1s or 1/5s, where N can be fractional, like 0.5/1s.
+// The duration can omit a leading 1, as in 5/m, and defaults to a second,
+// as in 50. Both max and 0 mean no rate limit.
+func (r *Rate) Set(value string) (err error) {
+	if value == "max" {
+		*r = Rate{}
+		return nil
+	}
+
+	ps := strings.SplitN(value, "/", 2)
+	freq, per := ps[0], time.Second
+	if len(ps) == 2 {
+		unit := ps[1]
+		if unit != "" && (unit[0] < '0' || unit[0] > '9') {
+			unit = "1" + unit
+		}
+		if per, err = time.ParseDuration(unit); err != nil || per <= 0 {
+			return fmt.Errorf("bad rate: %s", value)
+		}
+	}
+
+	// Fractional frequencies are made whole by scaling up the period,
+	// so 0.5/1s becomes 5/10s.
+	if i := strings.IndexByte(freq, '.'); i >= 0 {
+		for range freq[i+1:] {
+			if per > math.MaxInt64/10 {
+				return fmt.Errorf("bad rate: %s", value)
+			}
+			per *= 10
+		}
+		freq = freq[:i] + freq[i+1:]
+	}
+
+	n, err := strconv.ParseUint(freq, 10, 64)
+	if err != nil {
+		return fmt.Errorf("bad rate: %s", value)
+	}
+	r.Freq, r.Per = n, per
+	return nil
+}
+
+// String implements the fmt.Stringer interface.
+func (r Rate) String() string {
+	if r.Freq == 0 {
+		return "max"
+	}
+	return fmt.Sprintf("%d/%s", r.Freq, r.per())
+}
+
+func (r Rate) per() time.Duration {
+	if r.Per == 0 {
+		return time.Second
+	}
+	return r.Per
+}
+
+// LinearPacer is a Pacer whose rate changes linearly over time. It starts at
+// Start hits per second and grows by Slope hits per second every second.
+// A negative Slope ramps the rate down until it reaches zero, after which the
+// attack is stopped.
+type LinearPacer struct {
+	Start float64
+	Slope float64
+}
+
+// Pace implements the Pacer interface.
+func (lp LinearPacer) Pace(elapsed time.Duration, hits uint64) (time.Duration, bool) {
+	n := float64(hits)
+	if lp.Slope == 0 {
+		if lp.Start <= 0 {
+			return 0, true
+		}
+		return due(n/lp.Start, elapsed)
+	}
+
+	// The number of hits due by time t is the integral of the rate:
+	// Start*t + Slope*t²/2. Solve it for the time at which n hits are due.
+	// With a negative Slope, there are no more hits due once it's zero.
+	disc := lp.Start*lp.Start + 2*lp.Slope*n
+	if disc < 0 || disc == 0 && lp.Slope < 0 {
+		return 0, true
+	}
+	return due((math.Sqrt(disc)-lp.Start)/lp.Slope, elapsed)
+}
+
+// StepPacer is a Pacer whose rate changes in steps. It starts at Start hits
+// per second and changes by Step hits per second after each Every interval.
+// The rate never drops below zero and the attack is stopped once it
+// reaches zero for good.
+type StepPacer struct {
+	Start float64
+	Step  float64
+	Every time.Duration
+}
+
+// Pace implements the Pacer interface.
+func (sp StepPacer) Pace(elapsed time.Duration, hits uint64) (time.Duration, bool) {
+	if sp.Every <= 0 || sp.Step == 0 {
+		return LinearPacer{Start: sp.Start}.Pace(elapsed, hits)
+	}
+
+	// Steps whose rate isn't positive have no hits: skip them when going
+	// up and stop at the first one when going down.
+	every, n := sp.Every.Seconds(), float64(hits)
+	first, start, last := 0.0, sp.Start, math.Inf(1)
+	if start <= 0 {
+		if sp.Step < 0 {
+			return 0, true
+		}
+		first = math.Floor(-start/sp.Step) + 1
+		start += first * sp.Step
+	}
+
+	// The number of hits due after k whole steps is
+	// Every*(k*start + Step*k(k-1)/2). Solve it for the step in which the
+	// nth hit is due, correcting floating point errors either way.
+	sum := func(k float64) float64 { return every * (k*start + sp.Step*k*(k-1)/2) }
+	if sp.Step < 0 {
+		if last = math.Ceil(start / -sp.Step); n >= sum(last) {
+			return 0, true
+		}
+	}
+	b := start - sp.Step/2
+	k := math.Max(math.Floor((math.Sqrt(math.Max(b*b+2*sp.Step*n/every, 0))-b)/sp.Step), 0)
+	for k > 0 && sum(k) > n {
+		k--
+	}
+	for k+1 < last && sum(k+1) <= n {
+		k++
+	}
+	return due((first+k)*every+(n-sum(k))/(start+k*sp.Step), elapsed)
+}
+
+// SinePacer is a Pacer whose rate oscillates in a sine wave around Mean hits
+// per second with the given Amp(litude) and Period. StartAt is the phase, in
+// radians, at which the wave begins. The absolute value of Amp must not be
+// greater than Mean.
+type SinePacer struct {
+	Period  time.Duration
+	Mean    float64
+	Amp     float64
+	StartAt float64
+}
+
+// Pace implements the Pacer interface.
+func (sp SinePacer) Pace(elapsed time.Duration, hits uint64) (time.Duration, bool) {
+	if sp.Mean <= 0 || math.Abs(sp.Amp) > sp.Mean {
+		return 0, true
+	} else if sp.Period <= 0 || sp.Amp == 0 {
+		return LinearPacer{Start: sp.Mean}.Pace(elapsed, hits)
+	}
+
+	// The number of hits due by time t has no closed form inverse, so search
+	// for the time at which n hits are due. It's monotonic since |Amp| <= Mean.
+	n := float64(hits)
+	lo, hi := 0.0, math.Max(elapsed.Seconds(), 1)
+	for sp.hits(hi) < n {
+		lo, hi = hi, hi*2
+	}
+	for i := 0; i < 64 && hi-lo > 1e-9; i++ {
+		if mid := (lo + hi) / 2; sp.hits(mid) < n {
+			lo = mid
+		} else {
+			hi = mid
+		}
+	}
+	return due(hi, elapsed)
+}
+
+// hits returns the number of hits due by t seconds, which is the integral
+// of Mean + Amp*sin(2πt/Period + StartAt).
+func (sp SinePacer) hits(t float64) float64 {
+	w := 2 * math.Pi / sp.Period.Seconds()
+	return sp.Mean*t + sp.Amp/w*(math.Cos(sp.StartAt)-math.Cos(w*t+sp.StartAt))
+}
+
+// ReplayPacer is a Pacer which replays hits at the given Offsets since the
+// beginning of the attack, in order, scaled by Speed. A Speed of 2 replays
+// them twice as fast, 0.5 at half speed and zero means 1. The attack is
+// stopped after the last one.
+//
+// Offsets usually come from the Targets being replayed, which must then be
+// in the same order and be round-robined over. The Attacker sends the n-th
+// hit with the n-th Target it reads, so each is sent at its own offset.
+type ReplayPacer struct {
+	Offsets []time.Duration
+	Speed   float64
+}
+
+// Pace implements the Pacer interface.
+func (rp ReplayPacer) Pace(elapsed time.Duration, hits uint64) (time.Duration, bool) {
+	if hits >= uint64(len(rp.Offsets)) || rp.Speed < 0 {
+		return 0, true
+	}
+	speed := rp.Speed
+	if speed == 0 {
+		speed = 1
+	}
+	return due(rp.Offsets[hits].Seconds()/speed, elapsed)
+}
+
+// due returns the wait until the given time, in seconds since the
+// beginning of the attack.
+func due(at float64, elapsed time.Duration) (time.Duration, bool) {
+	if at*1e9 >= math.MaxInt64 {
+		return 0, true
+	}
+	return time.Duration(at*1e9) - elapsed, false
+}
@@ -0,0 +1,184 @@
+package vegeta
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestPacers(t *testing.T) {
+	t.Parallel()
+
+	for name, tc := range map[string]struct {
+		pacer Pacer
+		du    time.Duration
+		hits  uint64
+	}{
+		"rate":         {Rate{Freq: 100, Per: time.Second}, time.Second, 100},
+		"rate/negper":  {Rate{Freq: 1, Per: -time.Second}, time.Second, 0},
+		"rate/noper":   {Rate{Freq: 10}, time.Second, 10},
+		"rate/short":   {Rate{Freq: 100, Per: time.Second}, 500 * time.Millisecond, 50},
+		"rate/slow":    {Rate{Freq: 1, Per: 5 * time.Second}, 12 * time.Second, 3},
+		"rate/odd":     {Rate{Freq: 3, Per: time.Second}, 10 * time.Second, 30},
+		"linear":       {LinearPacer{Start: 10, Slope: 10}, 10 * time.Second, 600},
+		"linear/flat":  {LinearPacer{Start: 50}, 2 * time.Second, 100},
+		"linear/down":  {LinearPacer{Start: 100, Slope: -50}, 10 * time.Second, 100},
+		"step":         {StepPacer{Start: 10, Step: 10, Every: time.Second}, 3 * time.Second, 60},
+		"step/down":    {StepPacer{Start: 30, Step: -10, Every: time.Second}, 10 * time.Second, 60},
+		"sine":         {SinePacer{Period: time.Second, Mean: 100, Amp: 50}, time.Second, 100},
+		"sine/phase":   {SinePacer{Period: time.Second, Mean: 100, Amp: 100, StartAt: math.Pi}, 2 * time.Second, 200},
+		"sine/badamp":  {SinePacer{Period: time.Second, Mean: 10, Amp: 20}, time.Second, 0},
+		"sine/flatamp": {SinePacer{Mean: 10}, time.Second, 10},
+		"replay":       {ReplayPacer{Offsets: []time.Duration{0, 0, time.Second, 3 * time.Second}}, time.Hour, 4},
+		"replay/fast":  {ReplayPacer{Offsets: []time.Duration{0, time.Second, 3 * time.Second}, Speed: 2}, 2 * time.Second, 3},
+		"replay/slow":  {ReplayPacer{Offsets: []time.Duration{0, time.Second, 3 * time.Second}, Speed: 0.5}, 2 * time.Second, 1},
+	} {
+		if got := simulate(tc.pacer, tc.du); got != tc.hits {
+			t.Errorf("%s: want %d hits, got %d", name, tc.hits, got)
+		}
+	}
+}
+
+func TestRatePace(t *testing.T) {
+	t.Parallel()
+
+	r := Rate{Freq: 3, Per: time.Second}
+	for hits, want := range []time.Duration{0, 333333333, 666666666, time.Second} {
+		if got, stop := r.Pace(0, uint64(hits)); stop || got != want {
+			t.Errorf("hit %d: want %s, got %s (stop: %t)", hits, want, got, stop)
+		}
+	}
+	if got, _ := r.Pace(500*time.Millisecond, 1); got != -166666667 {
+		t.Errorf("want an overdue hit, got %s", got)
+	}
+	if got, stop := (Rate{}).Pace(time.Hour, math.MaxUint64); stop || got != 0 {
+		t.Errorf("want a zero Rate to never wait nor stop, got %s (stop: %t)", got, stop)
+	}
+	if _, stop := (Rate{Freq: 1, Per: time.Hour}).Pace(0, math.MaxUint64); !stop {
+		t.Error("want overflowing hit times to stop the attack")
+	}
+}
+
+func TestStepPacerPace(t *testing.T) {
+	t.Parallel()
+
+	// pace walks all steps up to the nth hit, as a reference.
+	pace := func(sp StepPacer, hits uint64) (time.Duration, bool) {
+		every := sp.Every.Seconds()
+		n, sum := float64(hits), 0.0
+		for k := 0.0; ; k++ {
+			rate := sp.Start + k*sp.Step
+			if rate <= 0 {
+				if sp.Step <= 0 {
+					return 0, true
+				}
+				continue
+			}
+			if left := n - sum; left < rate*every {
+				return due(k*every+left/rate, 0)
+			}
+			sum += rate * every
+		}
+	}
+
+	for _, sp := range []StepPacer{
+		{Start: 10, Step: 10, Every: time.Second},
+		{Start: 7, Step: 3, Every: 500 * time.Millisecond},
+		{Start: -25, Step: 10, Every: time.Second},
+		{Start: 0, Step: 0.5, Every: 2 * time.Second},
+		{Start: 30, Step: -10, Every: time.Second},
+		{Start: 25, Step: -10, Every: 100 * time.Millisecond},
+		{Start: 10, Every: time.Second},
+	} {
+		for hits := uint64(0); hits < 2000; hits++ {
+			want, wantStop := pace(sp, hits)
+			got, stop := sp.Pace(0, hits)
+			if stop != wantStop || !stop && (got-want > time.Microsecond || want-got > time.Microsecond) {
+				t.Fatalf("%+v, hit %d: want %s (stop: %t), got %s (stop: %t)", sp, hits, want, wantStop, got, stop)
+			}
+		}
+	}
+
+	// A day into a steady attack at 1000 hits per second.
+	sp := StepPacer{Start: 1000, Every: time.Second}
+	if got, stop := sp.Pace(0, 24*3600*1000); stop || got != 24*time.Hour {
+		t.Errorf("want a hit due after a day, got %s (stop: %t)", got, stop)
+	}
+	sp.Step = 1
+	if got, stop := sp.Pace(0, 24*3600*1000+24*3600*(24*3600-1)/2); stop || got != 24*time.Hour {
+		t.Errorf("want a hit due after a day, got %s (stop: %t)", got, stop)
+	}
+}
+
+func TestReplayPacerPace(t *testing.T) {
+	t.Parallel()
+
+	p := ReplayPacer{Offsets: []time.Duration{0, time.Second, 3 * time.Second}, Speed: 2}
+	for hits, want := range []time.Duration{0, 500 * time.Millisecond, 1500 * time.Millisecond} {
+		if got, stop := p.Pace(0, uint64(hits)); stop || got != want {
+			t.Errorf("hit %d: want %s, got %s (stop: %t)", hits, want, got, stop)
+		}
+	}
+	if _, stop := p.Pace(0, 3); !stop {
+		t.Error("want the attack to stop after the last offset")
+	}
+}
+
+func TestRateSet(t *testing.T) {
+	t.Parallel()
+
+	for value, want := range map[string]Rate{
+		"50":       {50, time.Second},
+		"5/1m":     {5, time.Minute},
+		"300/1s":   {300, time.Second},
+		"1/5s":     {1, 5 * time.Second},
+		"5/m":      {5, time.Minute},
+		"0.5/1s":   {5, 10 * time.Second},
+		"2.25":     {225, 100 * time.Second},
+		"10/100ms": {10, 100 * time.Millisecond},
+		"max":      {},
+		"0":        {0, time.Second},
+	} {
+		var got Rate
+		if err := got.Set(value); err != nil {
+			t.Errorf("%s: %s", value, err)
+		} else if got != want {
+			t.Errorf("%s: want %v, got %v", value, want, got)
+		}
+	}
+
+	for _, value := range []string{"", "x", "-1", "5/", "5/0s", "5/-1s", "5/1m/1s", "1.2.3"} {
+		var r Rate
+		if err := r.Set(value); err == nil {
+			t.Errorf("%q: want error, got %v", value, r)
+		}
+	}
+}
+
+func TestSinePacerShape(t *testing.T) {
+	t.Parallel()
+
+	// During the first half of the period the rate is above the mean and
+	// during the second half it is below it.
+	p := SinePacer{Period: 2 * time.Second, Mean: 100, Amp: 50}
+	if got := simulate(p, time.Second); got <= 100 {
+		t.Errorf("want more than 100 hits in the first half, got %d", got)
+	}
+	if got := simulate(p, 2*time.Second); got != 200 {
+		t.Errorf("want 200 hits in a full period, got %d", got)
+	}
+}
+
+// simulate returns the number of hits the given Pacer issues within du,
+// assuming every hit is sent exactly when it's due.
+func simulate(p Pacer, du time.Duration) (hits uint64) {
+	var elapsed time.Duration
+	for {
+		wait, stop := p.Pace(elapsed, hits)
+		if stop || elapsed+wait >= du {
+			return hits
+		}
+		elapsed += wait
+		hits++
+	}
+}
@@ -0,0 +1,222 @@
+package vegeta
+
+import (
+	"fmt"
+	"math"
+	"math/bits"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/bmizerany/perks/quantile"
+)
+
+// A PercentileEngine computes the latency percentiles of Metrics, trading
+// accuracy for memory in its own way.
+type PercentileEngine struct {
+	name string
+	new  func(qs []float64) estimator
+}
+
+// estimator estimates the quantiles of the values inserted into it.
+type estimator interface {
+	Insert(v float64)
+	Query(q float64) float64
+}
+
+var (
+	// ApproxPercentiles is the default PercentileEngine. It only keeps a
+	// targeted quantile stream in memory, whose percentiles are approximate.
+	ApproxPercentiles = PercentileEngine{"approx", func(qs []float64) estimator {
+		return quantile.NewTargeted(qs...)
+	}}
+	// ExactPercentiles keeps all latencies in memory to compute exact
+	// percentiles, which is only affordable for small attacks.
+	ExactPercentiles = PercentileEngine{"exact", func([]float64) estimator {
+		return &exactEstimator{}
+	}}
+)
+
+// HDRPercentiles returns a PercentileEngine which records latencies of up to
+// an hour in an HDR histogram, in constant memory, with the given number of
+// significant decimal digits (1 to 5) of precision for every percentile.
+// The memory used grows about tenfold with each digit, from 5KB with 1 digit
+// to 270KB with 3 and 27MB with 5, for each set of latencies.
+func HDRPercentiles(digits int) (PercentileEngine, error) {
+	if digits < 1 || digits > 5 {
+		return PercentileEngine{}, fmt.Errorf("bad significant digits: %d", digits)
+	}
+	return PercentileEngine{fmt.Sprintf("hdr[%d]", digits), func([]float64) estimator {
+		return newHDRHistogram(hdrHighest, digits)
+	}}, nil
+}
+
+// ParsePercentileEngine returns the PercentileEngine named by s, which is
+// one of approx, exact, hdr or hdr[digits]. hdr defaults to 3 digits.
+func ParsePercentileEngine(s string) (PercentileEngine, error) {
+	switch {
+	case s == ApproxPercentiles.name:
+		return ApproxPercentiles, nil
+	case s == ExactPercentiles.name:
+		return ExactPercentiles, nil
+	case s == "hdr":
+		return HDRPercentiles(3)
+	case strings.HasPrefix(s, "hdr[") && strings.HasSuffix(s, "]"):
+		digits, err := strconv.Atoi(s[4 : len(s)-1])
+		if err != nil {
+			return PercentileEngine{}, fmt.Errorf("bad significant digits: %s", s[4:len(s)-1])
+		}
+		return HDRPercentiles(digits)
+	}
+	return PercentileEngine{}, fmt.Errorf("bad percentile engine: %s", s)
+}
+
+// String implements the fmt.Stringer interface.
+func (e PercentileEngine) String() string {
+	if e.new == nil {
+		return ApproxPercentiles.name
+	}
+	return e.name
+}
+
+// estimator returns a new estimator of the given quantiles.
+func (e PercentileEngine) estimator(qs []float64) estimator {
+	if e.new == nil {
+		return ApproxPercentiles.new(qs)
+	}
+	return e.new(qs)
+}
+
+// Engine returns an option for NewMetrics which sets the PercentileEngine
+// used to compute latency percentiles, instead of ApproxPercentiles.
+func Engine(e PercentileEngine) func(*Metrics) {
+	return func(m *Metrics) { m.engine = e }
+}
+
+// exactEstimator keeps all values to compute exact, nearest-rank quantiles.
+type exactEstimator struct {
+	vs     []float64
+	sorted bool
+}
+
+func (e *exactEstimator) Insert(v float64) {
+	e.vs = append(e.vs, v)
+	e.sorted = false
+}
+
+func (e *exactEstimator) Query(q float64) float64 {
+	if len(e.vs) == 0 {
+		return 0
+	}
+	if !e.sorted {
+		sort.Float64s(e.vs)
+		e.sorted = true
+	}
+	return e.vs[rank(q, len(e.vs))-1]
+}
+
+// rank returns the nearest rank, from 1 to n, of the quantile q of n values.
+// Floating point noise in q, like 99.9/100 being 0.9990000000000001, doesn't
+// push it to the next rank.
+func rank(q float64, n int) int {
+	r := int(math.Ceil(q*float64(n) - 1e-9))
+	if r < 1 {
+		return 1
+	} else if r > n {
+		return n
+	}
+	return r
+}
+
+// hdrHighest is the highest latency, in nanoseconds, tracked by HDR
+// histograms. Higher ones are recorded as this.
+const hdrHighest = 3600 * 1e9
+
+// hdrHistogram is a High Dynamic Range histogram of nanosecond latencies,
+// laid out as in http://hdrhistogram.org. Values are counted in buckets of
+// sub-buckets, each bucket covering twice the range of the previous one with
+// the same number of sub-buckets, so that every value is recorded with the
+// same relative precision.
+type hdrHistogram struct {
+	highest          int64
+	max              int64
+	total            int64
+	subBucketHalfMag uint
+	subBucketCount   int64
+	subBucketMask    int64
+	counts           []int64
+}
+
+func newHDRHistogram(highest int64, digits int) *hdrHistogram {
+	largest := 2 * int64(math.Pow10(digits)) // largest value with single unit resolution
+	subBucketCountMag := uint(math.Ceil(math.Log2(float64(largest))))
+	h := &hdrHistogram{
+		highest:          highest,
+		subBucketHalfMag: subBucketCountMag - 1,
+		subBucketCount:   1 << subBucketCountMag,
+	}
+	h.subBucketMask = h.subBucketCount - 1
+
+	buckets := 1
+	for smallest := h.subBucketCount; smallest <= highest; smallest <<= 1 {
+		buckets++
+	}
+	h.counts = make([]int64, (buckets+1)*int(h.subBucketCount/2))
+	return h
+}
+
+func (h *hdrHistogram) Insert(v float64) {
+	n := int64(v)
+	if n < 0 {
+		n = 0
+	} else if n > h.highest {
+		n = h.highest
+	}
+	if n > h.max {
+		h.max = n
+	}
+	h.counts[h.index(n)]++
+	h.total++
+}
+
+func (h *hdrHistogram) Query(q float64) float64 {
+	if h.total == 0 {
+		return 0
+	}
+	r := int64(rank(q, int(h.total)))
+	var seen int64
+	for i, count := range h.counts {
+		if seen += count; seen >= r {
+			// The highest value equivalent to those counted at i, capped
+			// at the highest one recorded.
+			return float64(min64(h.highestEquivalent(i), h.max))
+		}
+	}
+	return float64(h.max)
+}
+
+// index returns the index of the count of v.
+func (h *hdrHistogram) index(v int64) int {
+	bucket := int64(63-int(h.subBucketHalfMag)) - int64(bits.LeadingZeros64(uint64(v|h.subBucketMask)))
+	subBucket := v >> uint(bucket)
+	return int((bucket+1)<<h.subBucketHalfMag + subBucket - h.subBucketCount/2)
+}
+
+// highestEquivalent returns the highest value counted at index i.
+func (h *hdrHistogram) highestEquivalent(i int) int64 {
+	half := h.subBucketCount / 2
+	bucket := int64(i>>h.subBucketHalfMag) - 1
+	subBucket := int64(i)&(half-1) + half
+	if bucket < 0 {
+		subBucket -= half
+		bucket = 0
+	}
+	return subBucket<<uint(bucket) + 1<<uint(bucket) - 1
+}
+
+func min64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
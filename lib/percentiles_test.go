@@ -0,0 +1,123 @@
+package vegeta
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func TestParsePercentileEngine(t *testing.T) {
+	t.Parallel()
+
+	for s, want := range map[string]string{
+		"approx":  "approx",
+		"exact":   "exact",
+		"hdr":     "hdr[3]",
+		"hdr[1]":  "hdr[1]",
+		"hdr[5]":  "hdr[5]",
+		"hdr[0]":  "bad significant digits: 0",
+		"hdr[6]":  "bad significant digits: 6",
+		"hdr[x]":  "bad significant digits: x",
+		"tdigest": "bad percentile engine: tdigest",
+	} {
+		e, err := ParsePercentileEngine(s)
+		got := e.String()
+		if err != nil {
+			got = err.Error()
+		}
+		if got != want {
+			t.Errorf("%s: want %q, got %q", s, want, got)
+		}
+	}
+}
+
+func TestExactPercentiles(t *testing.T) {
+	t.Parallel()
+
+	results := make(Results, 100)
+	for i, j := range rand.Perm(len(results)) {
+		results[i] = &Result{Timestamp: time.Unix(int64(i), 0), Latency: time.Duration(j+1) * time.Millisecond}
+	}
+
+	m := NewMetrics(results, Percentiles(1, 50, 99.9, 100), Engine(ExactPercentiles))
+	for _, p := range []struct {
+		percent float64
+		want    time.Duration
+	}{
+		{1, time.Millisecond},
+		{50, 50 * time.Millisecond},
+		{99.9, 100 * time.Millisecond},
+		{100, 100 * time.Millisecond},
+	} {
+		if got := m.Latencies.Percentiles.Get(p.percent); got != p.want {
+			t.Errorf("p%v: want %s, got %s", p.percent, p.want, got)
+		}
+	}
+	if m.Latencies.P95 != 95*time.Millisecond || m.Engine != "exact" {
+		t.Errorf("want exact P95 and engine, got %s and %s", m.Latencies.P95, m.Engine)
+	}
+}
+
+func TestHDRPercentiles(t *testing.T) {
+	t.Parallel()
+
+	rng := rand.New(rand.NewSource(1))
+	latencies := make([]float64, 100000)
+	for i := range latencies {
+		// Log-normally distributed around 10ms, with a long tail.
+		latencies[i] = math.Exp(rng.NormFloat64()) * 1e7
+	}
+
+	exact := ExactPercentiles.estimator(nil)
+	for _, l := range latencies {
+		exact.Insert(l)
+	}
+
+	for digits := 1; digits <= 5; digits++ {
+		engine, err := HDRPercentiles(digits)
+		if err != nil {
+			t.Fatal(err)
+		}
+		hdr := engine.estimator(nil)
+		for _, l := range latencies {
+			hdr.Insert(l)
+		}
+		precision := math.Pow10(-digits)
+		for _, q := range []float64{0.001, 0.5, 0.9, 0.99, 0.999, 1} {
+			want, got := math.Floor(exact.Query(q)), hdr.Query(q)
+			if math.Abs(got-want)/want > precision {
+				t.Errorf("digits %d, q%v: want %v within %v, got %v", digits, q, want, precision, got)
+			}
+		}
+	}
+
+	hdr := newHDRHistogram(hdrHighest, 3)
+	for _, v := range []float64{-1, 0, 2 * hdrHighest} {
+		hdr.Insert(v)
+	}
+	if min, max := hdr.Query(0), hdr.Query(1); min != 0 || max != hdrHighest {
+		t.Errorf("want out of range values clamped to [0, %v], got [%v, %v]", float64(hdrHighest), min, max)
+	}
+}
+
+func TestMetricsEngine(t *testing.T) {
+	t.Parallel()
+
+	if engine := NewMetrics(nil).Engine; engine != "approx" {
+		t.Errorf("want approx engine by default, got %s", engine)
+	}
+
+	hdr, err := HDRPercentiles(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	results := Results{&Result{Timestamp: time.Unix(0, 0), Latency: 123456789}}
+	m := NewMetrics(results, Engine(hdr))
+	if m.Engine != "hdr[2]" {
+		t.Errorf("want hdr[2] engine, got %s", m.Engine)
+	}
+	if m.Latencies.P99 != 123456789 {
+		t.Errorf("want single latency capped at the max, got %s", m.Latencies.P99)
+	}
+}
@@ -23,6 +23,14 @@ type ReporterFunc func(Results) ([]byte, error)
 // Report implements the Reporter interface.
 func (f ReporterFunc) Report(r Results) ([]byte, error) { return f(r) }
 
+// MetricsReporter is a Reporter which only needs the Metrics of the Results
+// it reports on, so that they can be computed as Results stream in, in
+// bounded memory, instead of collecting them all first.
+type MetricsReporter func(*Metrics) ([]byte, error)
+
+// Report implements the Reporter interface.
+func (f MetricsReporter) Report(r Results) ([]byte, error) { return f(NewMetrics(r)) }
+
 // HistogramReporter is a reporter that computes latency histograms with the
 // given buckets.
 type HistogramReporter []time.Duration
@@ -80,17 +88,42 @@ func (h HistogramReporter) String() string {
 
 // ReportText returns a computed Metrics struct as aligned, formatted text.
 var ReportText ReporterFunc = func(r Results) ([]byte, error) {
-	m := NewMetrics(r)
+	return ReportMetricsText(NewMetrics(r))
+}
+
+// ReportMetricsText is the MetricsReporter of ReportText, for Metrics
+// computed as Results stream in or with options.
+var ReportMetricsText MetricsReporter = func(m *Metrics) ([]byte, error) {
 	out := &bytes.Buffer{}
 
 	w := tabwriter.NewWriter(out, 0, 8, 2, '\t', tabwriter.StripEscape)
-	fmt.Fprintf(w, "Requests\t[total]\t%d\n", m.Requests)
+	fmt.Fprintf(w, "Requests\t[total, rate, throughput]\t%d, %.2f, %.2f\n", m.Requests, m.Rate, m.Throughput)
 	fmt.Fprintf(w, "Duration\t[total, attack, wait]\t%s, %s, %s\n", m.Duration+m.Wait, m.Duration, m.Wait)
-	fmt.Fprintf(w, "Latencies\t[mean, 50, 95, 99, max]\t%s, %s, %s, %s, %s\n",
-		m.Latencies.Mean, m.Latencies.P50, m.Latencies.P95, m.Latencies.P99, m.Latencies.Max)
+	fmt.Fprintf(w, "Percentiles\t[engine]\t%s\n", m.Engine)
+	for _, row := range []struct {
+		name string
+		l    *LatencyMetrics
+	}{
+		{"Latencies", &m.Latencies},
+		{"  DNS", &m.Phases.DNS},
+		{"  Connect", &m.Phases.Connect},
+		{"  TLS", &m.Phases.TLS},
+		{"  First Byte", &m.Phases.FirstByte},
+		{"  Body Read", &m.Phases.BodyRead},
+	} {
+		names, values := []string{"min", "mean"}, []string{row.l.Min.String(), row.l.Mean.String()}
+		for _, p := range row.l.Percentiles {
+			names = append(names, strconv.FormatFloat(p.Percent, 'f', -1, 64))
+			values = append(values, p.Latency.String())
+		}
+		names = append(names, "max", "stddev")
+		values = append(values, row.l.Max.String(), row.l.StdDev.String())
+		fmt.Fprintf(w, "%s\t[%s]\t%s\n", row.name, strings.Join(names, ", "), strings.Join(values, ", "))
+	}
 	fmt.Fprintf(w, "Bytes In\t[total, mean]\t%d, %.2f\n", m.BytesIn.Total, m.BytesIn.Mean)
 	fmt.Fprintf(w, "Bytes Out\t[total, mean]\t%d, %.2f\n", m.BytesOut.Total, m.BytesOut.Mean)
 	fmt.Fprintf(w, "Success\t[ratio]\t%.2f%%\n", m.Success*100)
+	fmt.Fprintf(w, "Reused\t[ratio]\t%.2f%%\n", m.Reused*100)
 	fmt.Fprintf(w, "Status Codes\t[code:count]\t")
 	for code, count := range m.StatusCodes {
 		fmt.Fprintf(w, "%s:%d  ", code, count)
@@ -108,7 +141,30 @@ var ReportText ReporterFunc = func(r Results) ([]byte, error) {
 
 // ReportJSON writes a computed Metrics struct to as JSON
 var ReportJSON ReporterFunc = func(r Results) ([]byte, error) {
-	return json.Marshal(NewMetrics(r))
+	return ReportMetricsJSON(NewMetrics(r))
+}
+
+// ReportMetricsJSON is the MetricsReporter of ReportJSON, for Metrics
+// computed as Results stream in or with options.
+var ReportMetricsJSON MetricsReporter = func(m *Metrics) ([]byte, error) {
+	return json.Marshal(m)
+}
+
+// ReportDump writes every Result as a JSON object in its own line, including
+// any captured response headers and body, which is written as a string.
+var ReportDump ReporterFunc = func(r Results) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, res := range r {
+		err := enc.Encode(struct {
+			*Result
+			Body string `json:"body"`
+		}{res, string(res.Body)})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
 }
 
 // ReportPlot builds up a self contained HTML page with an interactive plot
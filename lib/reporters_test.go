@@ -1,10 +1,47 @@
 package vegeta
 
 import (
+	"bytes"
+	"encoding/json"
+	"net/http"
 	"testing"
 	"time"
 )
 
+func TestReportDump(t *testing.T) {
+	t.Parallel()
+
+	results := Results{
+		&Result{Code: 200, Timestamp: time.Unix(0, 0)},
+		&Result{
+			Code:      502,
+			Timestamp: time.Unix(1, 0),
+			Header:    http.Header{"X-Error": []string{"1"}},
+			Body:      []byte(`{"error": "bad gateway"}`),
+		},
+	}
+	out, err := ReportDump(results)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lines := bytes.Split(bytes.TrimSpace(out), []byte("\n"))
+	if len(lines) != len(results) {
+		t.Fatalf("want %d lines, got %d: %s", len(results), len(lines), out)
+	}
+	var got struct {
+		Code   uint16
+		Header http.Header
+		Body   string
+	}
+	if err := json.Unmarshal(lines[1], &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Code != 502 || got.Header.Get("X-Error") != "1" || got.Body != string(results[1].Body) {
+		t.Fatalf("bad dump: %s", lines[1])
+	}
+}
+
 func BenchmarkReportPlot(b *testing.B) {
 	b.StopTimer()
 	// Build result set
@@ -25,3 +62,33 @@ func BenchmarkReportPlot(b *testing.B) {
 		ReportPlot(results)
 	}
 }
+
+func TestReportTextPercentiles(t *testing.T) {
+	t.Parallel()
+
+	results := Results{
+		&Result{Code: 200, Timestamp: time.Unix(0, 0), Latency: time.Millisecond},
+		&Result{Code: 200, Timestamp: time.Unix(1, 0), Latency: 2 * time.Millisecond},
+	}
+	out, err := ReportMetricsText(NewMetrics(results, Percentiles(90, 99.9), Engine(ExactPercentiles)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "[min, mean, 90, 99.9, max, stddev]"; bytes.Count(out, []byte(want)) != 6 {
+		t.Errorf("want latency rows with %q, got:\n%s", want, out)
+	}
+	if want := "1ms, 1.5ms, 2ms, 2ms, 2ms, 500µs\n"; !bytes.Contains(out, []byte(want)) {
+		t.Errorf("want latencies %q, got:\n%s", want, out)
+	}
+	if want := "\t2, 2.00, 2.00\n"; !bytes.Contains(out, []byte(want)) {
+		t.Errorf("want requests %q, got:\n%s", want, out)
+	}
+
+	want, err := ReportMetricsText(NewMetrics(results))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := ReportText(results); err != nil || !bytes.Equal(got, want) {
+		t.Errorf("want ReportText of Results like ReportMetricsText of their Metrics, got %v:\n%s", err, got)
+	}
+}
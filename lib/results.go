@@ -3,6 +3,7 @@ package vegeta
 import (
 	"encoding/gob"
 	"io"
+	"net/http"
 	"sync"
 	"time"
 )
@@ -14,12 +15,27 @@ func init() {
 // Result represents the metrics defined out of an http.Response
 // generated by each target hit
 type Result struct {
-	Code      uint16
-	Timestamp time.Time
-	Latency   time.Duration
-	BytesOut  uint64
-	BytesIn   uint64
-	Error     string
+	Code      uint16        `json:"code"`
+	Timestamp time.Time     `json:"timestamp"` // Intended send time of the hit
+	Latency   time.Duration `json:"latency"`   // Time elapsed since Timestamp until the response was read
+	BytesOut  uint64        `json:"bytes_out"`
+	BytesIn   uint64        `json:"bytes_in"`
+	Error     string        `json:"error"`
+	DNS       time.Duration `json:"dns"`        // DNS lookup time, if any
+	Connect   time.Duration `json:"connect"`    // TCP connect time, if a new connection was made
+	TLS       time.Duration `json:"tls"`        // TLS handshake time, if a new connection was made
+	FirstByte time.Duration `json:"first_byte"` // Time from writing the request until the first response byte
+	BodyRead  time.Duration `json:"body_read"`  // Time from the first response byte until the body was read
+	Reused    bool          `json:"reused"`     // Whether the request reused an idle connection
+	Expected  bool          `json:"expected"`   // Whether a status check expected the status code, even if not 2xx
+	Header    http.Header   `json:"header"`     // Response headers, if captured
+	Body      []byte        `json:"body"`       // Response body, if captured, up to the Attacker's MaxBody
+}
+
+// Success returns whether the hit succeeded, i.e. it got a 2xx response, or
+// one expected by a status check, without errors.
+func (r *Result) Success() bool {
+	return r.Error == "" && (r.Expected || r.Code >= 200 && r.Code < 300)
 }
 
 // Collect concurrently reads Results from multiple io.Readers until all of
@@ -3,33 +3,100 @@ package vegeta
 import (
 	"bufio"
 	"bytes"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
-	"io/ioutil"
+	"math"
+	"math/rand"
 	"net/http"
 	"net/url"
+	"os"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
+	"time"
+	"unicode/utf8"
 )
 
 // Target is an HTTP request blueprint.
 type Target struct {
-	Method string
-	URL    string
-	Body   []byte
-	Header http.Header
+	Method string      `json:"method"`
+	URL    string      `json:"url"`
+	Body   []byte      `json:"body,omitempty"`
+	Header http.Header `json:"header,omitempty"`
+	// BodyFile is the path of a file whose contents are streamed as the body
+	// of every request, instead of Body, without loading it into memory.
+	BodyFile string `json:"body_file,omitempty"`
+	// Form holds form fields which, along with Files, make up the body of
+	// every request instead of Body or BodyFile. It's URL encoded unless
+	// there are Files, in which case it's a multipart form.
+	Form url.Values `json:"form,omitempty"`
+	// Files maps form fields to the paths of the files uploaded as their
+	// values, streamed from disk in a multipart form.
+	Files map[string][]string `json:"files,omitempty"`
+	// Weight is the relative frequency with which a weighted Targeter
+	// returns the Target, up to MaxWeight. Zero counts as one.
+	Weight uint64 `json:"weight,omitempty"`
+	// Offset is the time since the beginning of an attack at which the
+	// Target is due when replayed with a ReplayPacer.
+	Offset time.Duration `json:"offset,omitempty"`
+	// Timeout, when positive, is the maximum amount of time a hit on the
+	// Target can take, including reading the response body, overriding the
+	// Attacker's own Timeout.
+	Timeout time.Duration `json:"timeout,omitempty"`
+	// Redirects, when set, is the maximum number of redirects followed by
+	// hits on the Target, overriding the Attacker's own Redirects.
+	Redirects *int `json:"redirects,omitempty"`
 }
 
 // Request creates an *http.Request out of Target and returns it along with an
-// error in case of failure.
+// error in case of failure. A BodyFile is opened and its ContentLength set from
+// its size, so it must not change during an attack.
 func (t *Target) Request() (*http.Request, error) {
+	if len(t.Form) > 0 || len(t.Files) > 0 {
+		return t.formRequest()
+	} else if t.BodyFile != "" {
+		return t.fileRequest()
+	}
 	req, err := http.NewRequest(t.Method, t.URL, bytes.NewBuffer(t.Body))
 	if err != nil {
 		return nil, err
 	}
+	t.setHeader(req)
+	return req, nil
+}
+
+// fileRequest creates an *http.Request which streams the Target's BodyFile.
+func (t *Target) fileRequest() (*http.Request, error) {
+	f, err := os.Open(t.BodyFile)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	req, err := http.NewRequest(t.Method, t.URL, f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	req.ContentLength = fi.Size()
+	req.GetBody = func() (io.ReadCloser, error) { return os.Open(t.BodyFile) }
+	if req.ContentLength == 0 {
+		f.Close()
+		req.Body = http.NoBody
+	}
+	t.setHeader(req)
+	return req, nil
+}
+
+func (t *Target) setHeader(req *http.Request) {
 	for k, vs := range t.Header {
 		req.Header[k] = make([]string, len(vs))
 		copy(req.Header[k], vs)
@@ -37,7 +104,6 @@ func (t *Target) Request() (*http.Request, error) {
 	if host := req.Header.Get("Host"); host != "" {
 		req.Host = host
 	}
-	return req, nil
 }
 
 // ErrNoTargets is returned when not enough Targets are available.
@@ -56,20 +122,73 @@ func NewStaticTargeter(tgts ...*Target) Targeter {
 	}
 }
 
+// NewRandomTargeter returns a Targeter which picks one of the passed Targets
+// uniformly at random on every invocation.
+func NewRandomTargeter(tgts ...*Target) Targeter {
+	return func() (*Target, error) {
+		return tgts[rand.Intn(len(tgts))], nil
+	}
+}
+
+// MaxWeight is the maximum Weight of a Target.
+const MaxWeight = math.MaxUint32
+
+// NewWeightedTargeter returns a Targeter which picks one of the passed Targets
+// at random on every invocation, with a probability proportional to its
+// Weight. It returns an error instead if the Weights add up to more than
+// math.MaxInt64.
+func NewWeightedTargeter(tgts ...*Target) Targeter {
+	sums := make([]uint64, len(tgts))
+	total := uint64(0)
+	for i, tgt := range tgts {
+		w := tgt.Weight
+		if w == 0 {
+			w = 1
+		}
+		if w > math.MaxInt64-total {
+			err := fmt.Errorf("bad weights: sum above %d", int64(math.MaxInt64))
+			return func() (*Target, error) { return nil, err }
+		}
+		total += w
+		sums[i] = total
+	}
+	return func() (*Target, error) {
+		n := uint64(rand.Int63n(int64(total)))
+		return tgts[sort.Search(len(sums), func(i int) bool { return sums[i] > n })], nil
+	}
+}
+
+// NewShuffledTargeter returns a Targeter which round-robins over the passed
+// Targets after shuffling them once.
+func NewShuffledTargeter(tgts ...*Target) Targeter {
+	shuffled := make([]*Target, len(tgts))
+	for i, j := range rand.Perm(len(tgts)) {
+		shuffled[i] = tgts[j]
+	}
+	return NewStaticTargeter(shuffled...)
+}
+
 // NewEagerTargeter eagerly reads all Targets out of the provided io.Reader and
 // returns a NewStaticTargeter with them.
 //
 // body will be set as the Target's body if no body is provided.
 // hdr will be merged with the each Target's headers.
 func NewEagerTargeter(src io.Reader, body []byte, header http.Header) (Targeter, error) {
-	var (
-		sc   = NewLazyTargeter(src, body, header)
-		tgts []*Target
-		tgt  *Target
-		err  error
-	)
+	tgts, err := ReadAllTargets(NewLazyTargeter(src, body, header))
+	if err != nil {
+		return nil, err
+	}
+	return NewStaticTargeter(tgts...), nil
+}
+
+// ReadAllTargets reads all Targets out of the provided Targeter until it
+// returns ErrNoTargets. An error is returned if it fails or if it had no
+// Targets at all.
+func ReadAllTargets(tr Targeter) ([]*Target, error) {
+	var tgts []*Target
 	for {
-		if tgt, err = sc(); err == ErrNoTargets {
+		tgt, err := tr()
+		if err == ErrNoTargets {
 			break
 		} else if err != nil {
 			return nil, err
@@ -79,79 +198,263 @@ func NewEagerTargeter(src io.Reader, body []byte, header http.Header) (Targeter,
 	if len(tgts) == 0 {
 		return nil, ErrNoTargets
 	}
-	return NewStaticTargeter(tgts...), nil
+	return tgts, nil
+}
+
+// NewJSONTargeter returns a new Targeter that lazily decodes Targets from
+// the provided io.Reader on every invocation. Targets are JSON objects,
+// usually one per line, with the following fields:
+//
+//	{"method": "POST", "url": "http://goku:9090/things", "header": {"X-Account-ID": ["99"]}, "body": "eyJuYW1lIjoiZ29rdSJ9"}
+//
+// where body is base64 encoded and every field but method and url, like
+// body_file, form, files, weight, redirects and offset and timeout, in
+// nanoseconds, is optional.
+//
+// body will be set as the Target's body if no body is provided.
+// hdr will be merged with the each Target's headers.
+func NewJSONTargeter(src io.Reader, body []byte, hdr http.Header) Targeter {
+	var mu sync.Mutex
+	dec := json.NewDecoder(src)
+	return func() (*Target, error) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		var t Target
+		if err := dec.Decode(&t); err == io.EOF {
+			return nil, ErrNoTargets
+		} else if err != nil {
+			return nil, fmt.Errorf("bad target: %s", err)
+		}
+		if !httpMethodChecker.MatchString(t.Method) {
+			return nil, fmt.Errorf("bad method: %q", t.Method)
+		}
+		if u, err := url.ParseRequestURI(t.URL); err != nil || u.Host == "" {
+			return nil, fmt.Errorf("bad URL: %s", t.URL)
+		}
+		if t.Weight > MaxWeight {
+			return nil, fmt.Errorf("bad weight: %d", t.Weight)
+		}
+
+		tgt := t
+		tgt.Header = http.Header{}
+		if tgt.Body == nil && tgt.BodyFile == "" {
+			tgt.Body = body
+		}
+		for k, vs := range hdr {
+			tgt.Header[k] = append([]string(nil), vs...)
+		}
+		for k, vs := range t.Header {
+			for _, v := range vs {
+				tgt.Header.Add(k, v)
+			}
+		}
+		return &tgt, nil
+	}
 }
 
 // NewLazyTargeter returns a new Targeter that lazily scans Targets from the
 // provided io.Reader on every invocation.
 //
+// Besides headers, targets can have the following lowercase directives:
+//
+//	weight: 10
+//	offset: 1.5s
+//	timeout: 200ms
+//	redirects: 0
+//	form: name=value
+//	file: field=@/path/to/file
+//
+// Targets with form fields or files get a URL encoded or, with files, a
+// multipart form body instead of any other body.
+//
+// Directives are case sensitive, so headers with the same names must be
+// capitalized.
+//
 // body will be set as the Target's body if no body is provided.
 // hdr will be merged with the each Target's headers.
 func NewLazyTargeter(src io.Reader, body []byte, hdr http.Header) Targeter {
-	var mu sync.Mutex
+	var (
+		mu     sync.Mutex
+		resync bool
+	)
 	sc := peekingScanner{src: bufio.NewScanner(src)}
+	done := func() (*Target, error) {
+		if err := sc.Err(); err != nil {
+			return nil, fmt.Errorf("bad targets: %s (line %d)", err, sc.Line()+1)
+		}
+		return nil, ErrNoTargets
+	}
 	return func() (*Target, error) {
 		mu.Lock()
 		defer mu.Unlock()
 
-		if !sc.Scan() {
-			return nil, ErrNoTargets
+		// After an error, skip the rest of the bad target, which ends with
+		// a blank line or the start of another target.
+		var raw, line string
+		for line == "" || resync && !startsWithHTTPMethod(line) {
+			if !sc.Scan() {
+				return done()
+			}
+			raw = sc.Text()
+			if line = strings.TrimSpace(raw); line == "" {
+				resync = false
+			}
+		}
+		resync = false
+
+		fail := func(at string, err error) (*Target, error) {
+			resync = true
+			return nil, &ParseError{Line: sc.Line(), Column: column(raw, at), Err: err}
 		}
+
 		tgt := Target{Body: body, Header: http.Header{}}
 		for k, vs := range hdr {
 			tgt.Header[k] = vs
 		}
-		line := strings.TrimSpace(sc.Text())
 		tokens := strings.SplitN(line, " ", 2)
 		if len(tokens) < 2 {
-			return nil, fmt.Errorf("bad target: %s", line)
+			return fail(line, fmt.Errorf("bad target: %s", line))
 		}
-		switch tokens[0] {
-		case "HEAD", "GET", "PUT", "POST", "PATCH", "OPTIONS":
-			tgt.Method = tokens[0]
-		default:
-			return nil, fmt.Errorf("bad method: %s", tokens[0])
+		if !httpMethodChecker.MatchString(tokens[0]) {
+			return fail(tokens[0], fmt.Errorf("bad method: %s", tokens[0]))
 		}
-		if _, err := url.ParseRequestURI(tokens[1]); err != nil {
-			return nil, fmt.Errorf("bad URL: %s", tokens[1])
+		tgt.Method = tokens[0]
+		if u, err := url.ParseRequestURI(tokens[1]); err != nil || u.Host == "" {
+			return fail(tokens[1], fmt.Errorf("bad URL: %s", tokens[1]))
 		}
 		tgt.URL = tokens[1]
 		line = strings.TrimSpace(sc.Peek())
-		if line == "" || startsWithHTTPMethod(line) {
+		if sc.Err() != nil {
+			return done()
+		} else if line == "" || startsWithHTTPMethod(line) {
 			return &tgt, nil
 		}
 		for sc.Scan() {
-			if line = strings.TrimSpace(sc.Text()); line == "" {
+			raw = sc.Text()
+			if line = strings.TrimSpace(raw); line == "" {
 				break
 			} else if strings.HasPrefix(line, "@") {
-				var err error
-				if tgt.Body, err = ioutil.ReadFile(line[1:]); err != nil {
-					return nil, fmt.Errorf("bad body: %s", err)
+				if fi, err := os.Stat(line[1:]); err != nil {
+					return fail(line[1:], fmt.Errorf("bad body: %s", err))
+				} else if fi.IsDir() {
+					return fail(line[1:], fmt.Errorf("bad body: %s is a directory", line[1:]))
 				}
+				tgt.Body, tgt.BodyFile = nil, line[1:]
 				break
 			}
 			tokens = strings.SplitN(line, ":", 2)
 			if len(tokens) < 2 {
-				return nil, fmt.Errorf("bad header: %s", line)
+				return fail(line, fmt.Errorf("bad header: %s", line))
 			}
 			for i := range tokens {
 				if tokens[i] = strings.TrimSpace(tokens[i]); tokens[i] == "" {
-					return nil, fmt.Errorf("bad header: %s", line)
+					return fail(line, fmt.Errorf("bad header: %s", line))
+				}
+			}
+			if set, ok := directives[tokens[0]]; ok {
+				if err := set(&tgt, tokens[1]); err != nil {
+					return fail(tokens[1], err)
 				}
+				continue
 			}
 			tgt.Header.Add(tokens[0], tokens[1])
 		}
-		if err := sc.Err(); err != nil {
-			return nil, ErrNoTargets
+		if sc.Err() != nil {
+			return done()
 		}
 		return &tgt, nil
 	}
 }
 
-var httpMethodChecker = regexp.MustCompile("^(HEAD|GET|PUT|POST|PATCH|OPTIONS) ")
+// ParseError is returned by Targeters when a target is malformed, along with
+// the position at which the problem was found.
+type ParseError struct {
+	Line   int
+	Column int
+	Err    error
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("%s (line %d, column %d)", e.Err, e.Line, e.Column)
+}
+
+// column returns the 1-based column of the given token in line.
+func column(line, token string) int {
+	if i := strings.Index(line, token); i >= 0 {
+		return utf8.RuneCountInString(line[:i]) + 1
+	}
+	return 1
+}
 
+// directives set the Target settings which can be given in place of headers.
+var directives = map[string]func(*Target, string) error{
+	"weight": func(tgt *Target, v string) (err error) {
+		if tgt.Weight, err = strconv.ParseUint(v, 10, 64); err != nil || tgt.Weight == 0 || tgt.Weight > MaxWeight {
+			return fmt.Errorf("bad weight: %s", v)
+		}
+		return nil
+	},
+	"offset": func(tgt *Target, v string) (err error) {
+		if tgt.Offset, err = time.ParseDuration(v); err != nil {
+			return fmt.Errorf("bad offset: %s", v)
+		}
+		return nil
+	},
+	"timeout": func(tgt *Target, v string) (err error) {
+		if tgt.Timeout, err = time.ParseDuration(v); err != nil || tgt.Timeout <= 0 {
+			return fmt.Errorf("bad timeout: %s", v)
+		}
+		return nil
+	},
+	"redirects": func(tgt *Target, v string) error {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return fmt.Errorf("bad redirects: %s", v)
+		}
+		tgt.Redirects = &n
+		return nil
+	},
+	"form": func(tgt *Target, v string) error {
+		kv := strings.SplitN(v, "=", 2)
+		if len(kv) < 2 || kv[0] == "" {
+			return fmt.Errorf("bad form field: %s", v)
+		}
+		if tgt.Form == nil {
+			tgt.Form = url.Values{}
+		}
+		tgt.Form.Add(kv[0], kv[1])
+		return nil
+	},
+	"file": func(tgt *Target, v string) error {
+		kv := strings.SplitN(v, "=", 2)
+		if len(kv) < 2 || kv[0] == "" || !strings.HasPrefix(kv[1], "@") {
+			return fmt.Errorf("bad form file: %s", v)
+		}
+		if fi, err := os.Stat(kv[1][1:]); err != nil || fi.IsDir() {
+			return fmt.Errorf("bad form file: %s", v)
+		}
+		if tgt.Files == nil {
+			tgt.Files = map[string][]string{}
+		}
+		tgt.Files[kv[0]] = append(tgt.Files[kv[0]], kv[1][1:])
+		return nil
+	},
+}
+
+// httpMethodChecker matches HTTP methods, which can be any RFC 7230 token,
+// so that extension methods like PURGE or PROPFIND are supported.
+var httpMethodChecker = regexp.MustCompile("^[!#$%&'*+\\-.^_`|~0-9A-Za-z]+$")
+
+// startsWithHTTPMethod returns whether the given line starts a new target,
+// i.e. whether it's made of an HTTP method and a URL.
 func startsWithHTTPMethod(t string) bool {
-	return httpMethodChecker.MatchString(t)
+	tokens := strings.SplitN(t, " ", 2)
+	if len(tokens) < 2 || !httpMethodChecker.MatchString(tokens[0]) {
+		return false
+	}
+	_, err := url.ParseRequestURI(tokens[1])
+	return err == nil
 }
 
 // Wrap a Scanner so we can cheat and look at the next value and react accordingly,
@@ -159,6 +462,7 @@ func startsWithHTTPMethod(t string) bool {
 type peekingScanner struct {
 	src    *bufio.Scanner
 	peeked string
+	lines  int
 }
 
 func (s *peekingScanner) Err() error {
@@ -169,13 +473,17 @@ func (s *peekingScanner) Peek() string {
 	if !s.src.Scan() {
 		return ""
 	}
+	s.lines++
 	s.peeked = s.src.Text()
 	return s.peeked
 }
 
 func (s *peekingScanner) Scan() bool {
 	if s.peeked == "" {
-		return s.src.Scan()
+		if !s.src.Scan() {
+			return false
+		}
+		s.lines++
 	}
 	return true
 }
@@ -188,3 +496,8 @@ func (s *peekingScanner) Text() string {
 	s.peeked = ""
 	return t
 }
+
+// Line returns the line number of the last line returned by Text.
+func (s *peekingScanner) Line() int {
+	return s.lines
+}
@@ -1,17 +1,20 @@
 package vegeta
 
 import (
+	"bufio"
 	"bytes"
 	"crypto/rand"
 	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"math"
 	"net/http"
 	"os"
 	"reflect"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestTargetRequest(t *testing.T) {
@@ -58,6 +61,45 @@ func TestTargetRequest(t *testing.T) {
 	}
 }
 
+func TestTargetRequestBodyFile(t *testing.T) {
+	t.Parallel()
+
+	bodyf, err := ioutil.TempFile("", "vegeta-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(bodyf.Name())
+	bodyf.WriteString("Hello world!")
+	bodyf.Close()
+
+	tgt := Target{Method: "PUT", URL: "http://:9999/", Body: []byte("ignored"), BodyFile: bodyf.Name()}
+	req, err := tgt.Request()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.ContentLength != 12 {
+		t.Errorf("want ContentLength 12, got %d", req.ContentLength)
+	}
+	for i, rc := range []func() (io.ReadCloser, error){
+		func() (io.ReadCloser, error) { return req.Body, nil },
+		req.GetBody,
+	} {
+		body, err := rc()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bs, _ := ioutil.ReadAll(body); string(bs) != "Hello world!" {
+			t.Errorf("%d: want body %q, got %q", i, "Hello world!", bs)
+		}
+		body.Close()
+	}
+
+	tgt.BodyFile = bodyf.Name() + ".missing"
+	if _, err := tgt.Request(); err == nil {
+		t.Error("want an error with a missing body file")
+	}
+}
+
 func TestNewEagerTargeter(t *testing.T) {
 	t.Parallel()
 
@@ -88,10 +130,81 @@ func TestNewEagerTargeter(t *testing.T) {
 	}
 }
 
+func TestNewRandomTargeter(t *testing.T) {
+	t.Parallel()
+
+	tgts := []*Target{{URL: "http://:6060/a"}, {URL: "http://:6060/b"}, {URL: "http://:6060/c"}}
+	read := NewRandomTargeter(tgts...)
+	hits := map[*Target]int{}
+	for i := 0; i < 1000; i++ {
+		tgt, _ := read()
+		hits[tgt]++
+	}
+	for _, tgt := range tgts {
+		if hits[tgt] < 200 {
+			t.Errorf("%s: want ~333 hits, got %d", tgt.URL, hits[tgt])
+		}
+	}
+}
+
+func TestNewWeightedTargeter(t *testing.T) {
+	t.Parallel()
+
+	tgts := []*Target{{URL: "http://:6060/checkout"}, {URL: "http://:6060/browse", Weight: 9}}
+	read := NewWeightedTargeter(tgts...)
+	hits := map[*Target]int{}
+	for i := 0; i < 10000; i++ {
+		tgt, _ := read()
+		hits[tgt]++
+	}
+	if got := hits[tgts[0]]; got < 800 || got > 1200 {
+		t.Errorf("want ~1000 hits with a zero weight, got %d", got)
+	}
+
+	heavy := &Target{URL: "http://:6060/", Weight: math.MaxInt64}
+	if _, err := NewWeightedTargeter(heavy, heavy)(); err == nil {
+		t.Error("want an error with weights above math.MaxInt64")
+	}
+	big := fmt.Sprintf("GET http://:6060/\nweight: %d\n", uint64(MaxWeight)+1)
+	if _, err := NewLazyTargeter(strings.NewReader(big), nil, nil)(); err == nil || !strings.HasPrefix(err.Error(), "bad weight") {
+		t.Errorf("want bad weight above MaxWeight, got %v", err)
+	}
+}
+
+func TestNewShuffledTargeter(t *testing.T) {
+	t.Parallel()
+
+	tgts := make([]*Target, 100)
+	for i := range tgts {
+		tgts[i] = &Target{URL: fmt.Sprintf("http://:6060/%d", i)}
+	}
+	read := NewShuffledTargeter(tgts...)
+
+	var first []*Target
+	for round := 0; round < 2; round++ {
+		seen := map[*Target]bool{}
+		for i := range tgts {
+			tgt, _ := read()
+			if round == 0 {
+				first = append(first, tgt)
+			} else if first[i] != tgt {
+				t.Fatalf("want the same order on every round, got %s at %d", tgt.URL, i)
+			}
+			seen[tgt] = true
+		}
+		if len(seen) != len(tgts) {
+			t.Fatalf("want all %d targets once per round, got %d", len(tgts), len(seen))
+		}
+	}
+	if reflect.DeepEqual(first, tgts) {
+		t.Error("want shuffled targets, got the original order")
+	}
+}
+
 func TestNewLazyTargeter(t *testing.T) {
 	for want, def := range map[error]string{
 		errors.New("bad target"): "GET",
-		errors.New("bad method"): "SET http://:6060",
+		errors.New("bad method"): "G(E)T http://:6060",
 		errors.New("bad URL"):    "GET foobar",
 		errors.New("bad body"): `
 			GET http://:6060
@@ -105,6 +218,18 @@ func TestNewLazyTargeter(t *testing.T) {
 		errors.New("bad header"): `
 			GET http://:6060
 			: 1234`,
+		errors.New("bad weight"): `
+			GET http://:6060
+			weight: 0`,
+		errors.New("bad offset"): `
+			GET http://:6060
+			offset: soon`,
+		errors.New("bad timeout"): `
+			GET http://:6060
+			timeout: -1s`,
+		errors.New("bad redirects"): `
+			GET http://:6060
+			redirects: none`,
 	} {
 		src := bytes.NewBufferString(strings.TrimSpace(def))
 		read := NewLazyTargeter(src, []byte{}, http.Header{})
@@ -125,8 +250,18 @@ func TestNewLazyTargeter(t *testing.T) {
 		GET http://:6060/
 		X-Header: 1
 		X-Header: 2
+		weight: 3
+		Weight: heavy
 
 		PUT https://:6060/123
+		PURGE http://:6060/cached
+		timeout: 200ms
+		redirects: 0
+
+		DELETE http://:6060/123
+		PROPFIND http://:6060/dav
+		Depth: 1
+		offset: 1.5s
 
 		POST http://foobar.org/fnord
 		Authorization: x12345
@@ -142,8 +277,10 @@ func TestNewLazyTargeter(t *testing.T) {
 			Body:   []byte{},
 			Header: http.Header{
 				"X-Header":     []string{"1", "2"},
+				"Weight":       []string{"heavy"},
 				"Content-Type": []string{"text/plain"},
 			},
+			Weight: 3,
 		},
 		&Target{
 			Method: "PUT",
@@ -152,9 +289,33 @@ func TestNewLazyTargeter(t *testing.T) {
 			Header: http.Header{"Content-Type": []string{"text/plain"}},
 		},
 		&Target{
-			Method: "POST",
-			URL:    "http://foobar.org/fnord",
-			Body:   []byte("Hello world!"),
+			Method:    "PURGE",
+			URL:       "http://:6060/cached",
+			Body:      []byte{},
+			Header:    http.Header{"Content-Type": []string{"text/plain"}},
+			Timeout:   200 * time.Millisecond,
+			Redirects: new(int),
+		},
+		&Target{
+			Method: "DELETE",
+			URL:    "http://:6060/123",
+			Body:   []byte{},
+			Header: http.Header{"Content-Type": []string{"text/plain"}},
+		},
+		&Target{
+			Method: "PROPFIND",
+			URL:    "http://:6060/dav",
+			Body:   []byte{},
+			Header: http.Header{
+				"Depth":        []string{"1"},
+				"Content-Type": []string{"text/plain"},
+			},
+			Offset: 1500 * time.Millisecond,
+		},
+		&Target{
+			Method:   "POST",
+			URL:      "http://foobar.org/fnord",
+			BodyFile: bodyf.Name(),
 			Header: http.Header{
 				"Authorization": []string{"x12345"},
 				"Content-Type":  []string{"text/plain"},
@@ -173,3 +334,117 @@ func TestNewLazyTargeter(t *testing.T) {
 		t.Fatalf("got: %v, want: %v", got, nil)
 	}
 }
+
+func TestNewLazyTargeterErrors(t *testing.T) {
+	t.Parallel()
+
+	src := strings.Join([]string{
+		"GET http://:6060/a",
+		"",
+		"",
+		"POST http://:6060/b",
+		"weight: 0",
+		"X-Skipped: 1",
+		"",
+		"  G(E)T http://:6060/",
+		"",
+		"DELETE foobar",
+		"GET http://:6060/c",
+		"Broken",
+		"HEAD http://:6060/d",
+		"",
+		"PUT /e",
+	}, "\n")
+
+	read := NewLazyTargeter(strings.NewReader(src), nil, nil)
+	for i, want := range []string{
+		"http://:6060/a",
+		"bad weight: 0 (line 5, column 9)",
+		"bad method: G(E)T (line 8, column 3)",
+		"bad URL: foobar (line 10, column 8)",
+		"bad header: Broken (line 12, column 1)",
+		"http://:6060/d",
+		"bad URL: /e (line 15, column 5)",
+	} {
+		tgt, err := read()
+		if err != nil {
+			if _, ok := err.(*ParseError); !ok || err.Error() != want {
+				t.Errorf("%d: want %s, got %#v", i, want, err)
+			}
+		} else if tgt.URL != want {
+			t.Errorf("%d: want %s, got target %s", i, want, tgt.URL)
+		}
+	}
+	if _, err := read(); err != ErrNoTargets {
+		t.Fatalf("got: %v, want: %v", err, ErrNoTargets)
+	}
+
+	long := "GET http://:6060/a\nX-Long: " + strings.Repeat("x", bufio.MaxScanTokenSize) + "\n"
+	read = NewLazyTargeter(strings.NewReader(long), nil, nil)
+	want := "bad targets: bufio.Scanner: token too long (line 2)"
+	if _, err := read(); err == nil || err == ErrNoTargets || err.Error() != want {
+		t.Errorf("want %s, got %v", want, err)
+	}
+}
+
+func TestNewJSONTargeter(t *testing.T) {
+	t.Parallel()
+
+	for want, def := range map[string]string{
+		"bad target":             `{"method": "GET", "url": "http://:6060"`,
+		"bad method":             `{"url": "http://:6060"}`,
+		`bad method: "GET POST"`: `{"method": "GET POST", "url": "http://:6060"}`,
+		"bad URL":                `{"method": "GET", "url": "foobar"}`,
+		"bad URL: /foobar":       `{"method": "GET", "url": "/foobar"}`,
+	} {
+		read := NewJSONTargeter(strings.NewReader(def), nil, nil)
+		if _, got := read(); got == nil || !strings.HasPrefix(got.Error(), want) {
+			t.Errorf("got: %s, want: %s\n%s", got, want, def)
+		}
+	}
+
+	targets := `
+		{"method": "GET", "url": "http://:6060/", "header": {"X-Header": ["1", "2"], "Weight": ["heavy"]}, "weight": 3}
+		{"method": "PURGE", "url": "https://:6060/123", "offset": 1500000000}
+
+		{"method": "POST", "url": "http://foobar.org/fnord", "header": {"Content-Type": ["application/octet-stream"]}, "body": "AAEC/w=="}
+	`
+	read := NewJSONTargeter(strings.NewReader(targets), []byte("default"), http.Header{"Content-Type": []string{"text/plain"}})
+	for _, want := range []*Target{
+		&Target{
+			Method: "GET",
+			URL:    "http://:6060/",
+			Body:   []byte("default"),
+			Header: http.Header{
+				"X-Header":     []string{"1", "2"},
+				"Weight":       []string{"heavy"},
+				"Content-Type": []string{"text/plain"},
+			},
+			Weight: 3,
+		},
+		&Target{
+			Method: "PURGE",
+			URL:    "https://:6060/123",
+			Body:   []byte("default"),
+			Header: http.Header{"Content-Type": []string{"text/plain"}},
+			Offset: 1500 * time.Millisecond,
+		},
+		&Target{
+			Method: "POST",
+			URL:    "http://foobar.org/fnord",
+			Body:   []byte{0, 1, 2, 255},
+			Header: http.Header{"Content-Type": []string{"text/plain", "application/octet-stream"}},
+		},
+	} {
+		if got, err := read(); err != nil {
+			t.Fatal(err)
+		} else if !reflect.DeepEqual(want, got) {
+			t.Fatalf("want: %#v, got: %#v", want, got)
+		}
+	}
+	if got, err := read(); err != ErrNoTargets {
+		t.Fatalf("got: %v, want: %v", err, ErrNoTargets)
+	} else if got != nil {
+		t.Fatalf("got: %v, want: %v", got, nil)
+	}
+}
@@ -0,0 +1,260 @@
+package vegeta
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"io/ioutil"
+	mrand "math/rand"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/template"
+	"time"
+	"unicode/utf8"
+)
+
+// A VarSource returns the variables available to the templates of a Target
+// on every hit, accessible as {{.name}}.
+type VarSource func() map[string]string
+
+// NewCSVVarSource reads all records out of the provided CSV io.Reader and
+// returns a VarSource which round-robins over them. The first record names
+// the variables of each column.
+func NewCSVVarSource(src io.Reader) (VarSource, error) {
+	records, err := csv.NewReader(src).ReadAll()
+	if err != nil {
+		return nil, err
+	} else if len(records) < 2 {
+		return nil, fmt.Errorf("no variables: want a header and at least one record")
+	}
+
+	names, rows := records[0], make([]map[string]string, len(records)-1)
+	for i, record := range records[1:] {
+		rows[i] = make(map[string]string, len(names))
+		for j, name := range names {
+			rows[i][name] = record[j]
+		}
+	}
+
+	i := int64(-1)
+	return func() map[string]string {
+		return rows[atomic.AddInt64(&i, 1)%int64(len(rows))]
+	}, nil
+}
+
+// NewTemplateTargeter returns a Targeter which renders the URL, header values,
+// form values and body of the Targets returned by tr as text/template
+// templates on every invocation. A UTF-8 BodyFile containing templates is read
+// into memory once and rendered into the Body, while others are still
+// streamed. Besides the variables from vars, which can be nil, templates can
+// use the following functions:
+//
+//	{{seq}}             the sequence number of the hit, starting at 0
+//	{{uuid}}            a random (version 4) UUID
+//	{{randint 1 10000}} a random integer in the given closed interval
+//	{{now}}             the current time in RFC 3339 format
+//	{{now "unix"}}      the current Unix time, or any other time layout
+func NewTemplateTargeter(tr Targeter, vars VarSource) Targeter {
+	var seq uint64
+	ts := templates{m: map[string]*template.Template{}, files: map[string]*string{}}
+	return func() (*Target, error) {
+		tgt, err := tr()
+		if err != nil {
+			return nil, err
+		}
+
+		n, now := atomic.AddUint64(&seq, 1)-1, time.Now()
+		fns := template.FuncMap{
+			"seq": func() uint64 { return n },
+			"now": func(layout ...string) string { return formatTime(now, layout) },
+		}
+		data := map[string]string{}
+		if vars != nil {
+			data = vars()
+		}
+		render := func(text string) (string, error) {
+			if !strings.Contains(text, "{{") {
+				return text, nil
+			}
+			return ts.render(text, fns, data)
+		}
+
+		out := *tgt
+		if out.URL, err = render(tgt.URL); err != nil {
+			return nil, err
+		}
+		if tgt.Header != nil {
+			out.Header = make(http.Header, len(tgt.Header))
+			for k, vs := range tgt.Header {
+				out.Header[k] = make([]string, len(vs))
+				for i, v := range vs {
+					if out.Header[k][i], err = render(v); err != nil {
+						return nil, err
+					}
+				}
+			}
+		}
+		if tgt.Form != nil {
+			out.Form = make(url.Values, len(tgt.Form))
+			for k, vs := range tgt.Form {
+				out.Form[k] = make([]string, len(vs))
+				for i, v := range vs {
+					if out.Form[k][i], err = render(v); err != nil {
+						return nil, err
+					}
+				}
+			}
+		}
+
+		body := string(tgt.Body)
+		if tgt.BodyFile != "" {
+			text, err := ts.file(tgt.BodyFile)
+			if err != nil {
+				return nil, err
+			} else if text == nil {
+				return &out, nil // Not a template, so it's still streamed.
+			}
+			body, out.BodyFile = *text, ""
+		}
+		if strings.Contains(body, "{{") {
+			if body, err = render(body); err != nil {
+				return nil, err
+			}
+			out.Body = []byte(body)
+		}
+		return &out, nil
+	}
+}
+
+// templates is a concurrency safe cache of parsed templates and of the
+// contents of body files, which are nil for those without templates.
+type templates struct {
+	mu    sync.Mutex
+	m     map[string]*template.Template
+	files map[string]*string
+}
+
+// templateFuncs are the functions templates are parsed with. The ones which
+// depend on the hit are overridden when rendering.
+var templateFuncs = template.FuncMap{
+	"seq":     func() uint64 { return 0 },
+	"now":     func(...string) string { return "" },
+	"uuid":    uuid,
+	"randint": randint,
+}
+
+// render executes the template defined by text with the given functions
+// and data.
+func (ts *templates) render(text string, fns template.FuncMap, data interface{}) (string, error) {
+	ts.mu.Lock()
+	t, ok := ts.m[text]
+	if !ok {
+		var err error
+		t, err = template.New("").Funcs(templateFuncs).Option("missingkey=error").Parse(text)
+		if err != nil {
+			ts.mu.Unlock()
+			return "", fmt.Errorf("bad template: %s", err)
+		}
+		ts.m[text] = t
+	}
+	ts.mu.Unlock()
+
+	t, err := t.Clone()
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err = t.Funcs(fns).Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("bad template: %s", err)
+	}
+	return buf.String(), nil
+}
+
+// file returns the contents of the body file at path, read once, or nil
+// if it has no templates or isn't UTF-8 text, like binary files.
+func (ts *templates) file(path string) (*string, error) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	text, ok := ts.files[path]
+	if !ok {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+
+		// Only files with templates are read whole, after looking for
+		// the first one without holding the file in memory.
+		if found, err := hasTemplates(f); err != nil {
+			return nil, err
+		} else if found {
+			if _, err = f.Seek(0, io.SeekStart); err != nil {
+				return nil, err
+			}
+			data, err := ioutil.ReadAll(f)
+			if err != nil {
+				return nil, err
+			}
+			if utf8.Valid(data) {
+				s := string(data)
+				text = &s
+			}
+		}
+		ts.files[path] = text
+	}
+	return text, nil
+}
+
+// hasTemplates returns whether r contains "{{", reading it in chunks.
+func hasTemplates(r io.Reader) (bool, error) {
+	// The first byte holds the last one of the previous chunk, so that
+	// a "{{" split between two chunks is found.
+	buf := make([]byte, 1+32*1024)
+	for {
+		n, err := r.Read(buf[1:])
+		if bytes.Contains(buf[:1+n], []byte("{{")) {
+			return true, nil
+		} else if n > 0 {
+			buf[0] = buf[n]
+		}
+		if err == io.EOF {
+			return false, nil
+		} else if err != nil {
+			return false, err
+		}
+	}
+}
+
+func formatTime(t time.Time, layout []string) string {
+	if len(layout) == 0 {
+		return t.Format(time.RFC3339)
+	} else if layout[0] == "unix" {
+		return strconv.FormatInt(t.Unix(), 10)
+	}
+	return t.Format(layout[0])
+}
+
+func uuid() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	b[6] = b[6]&0x0f | 0x40 // Version 4
+	b[8] = b[8]&0x3f | 0x80 // RFC 4122 variant
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
+
+func randint(min, max int) (int, error) {
+	if max < min {
+		return 0, fmt.Errorf("randint: %d > %d", min, max)
+	}
+	return min + mrand.Intn(max-min+1), nil
+}
@@ -0,0 +1,156 @@
+package vegeta
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+func TestNewTemplateTargeter(t *testing.T) {
+	t.Parallel()
+
+	vars, err := NewCSVVarSource(strings.NewReader("user,token\ngoku,abc\nvegeta,xyz\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tgt := &Target{
+		Method: "POST",
+		URL:    "http://:6060/users/{{.user}}/orders/{{seq}}",
+		Header: http.Header{
+			"Authorization": []string{"Token {{.token}}"},
+			"X-Request-Id":  []string{"{{uuid}}"},
+			"X-Static":      []string{"static"},
+		},
+		Body: []byte(`{"id": {{seq}}, "qty": {{randint 1 3}}, "at": {{now "unix"}}}`),
+	}
+	read := NewTemplateTargeter(NewStaticTargeter(tgt), vars)
+
+	uuidRe := regexp.MustCompile("^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$")
+	bodyRe := regexp.MustCompile(`^{"id": (\d+), "qty": ([1-3]), "at": (\d+)}$`)
+	for i, user := range []string{"goku", "vegeta", "goku"} {
+		got, err := read()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := "http://:6060/users/" + user + "/orders/" + strconv.Itoa(i); got.URL != want {
+			t.Errorf("URL: want %s, got %s", want, got.URL)
+		}
+		if got.Header.Get("Authorization") == tgt.Header.Get("Authorization") {
+			t.Errorf("Authorization: not rendered: %s", got.Header.Get("Authorization"))
+		}
+		if id := got.Header.Get("X-Request-Id"); !uuidRe.MatchString(id) {
+			t.Errorf("X-Request-Id: bad UUID: %s", id)
+		}
+		if got.Header.Get("X-Static") != "static" {
+			t.Errorf("X-Static: want static, got %s", got.Header.Get("X-Static"))
+		}
+		m := bodyRe.FindStringSubmatch(string(got.Body))
+		if m == nil {
+			t.Fatalf("Body: bad render: %s", got.Body)
+		}
+		if m[1] != strconv.Itoa(i) {
+			t.Errorf("Body: want seq %d, got %s", i, m[1])
+		}
+		if at, _ := strconv.ParseInt(m[3], 10, 64); time.Since(time.Unix(at, 0)) > time.Minute {
+			t.Errorf("Body: bad time: %s", m[3])
+		}
+	}
+
+	if !strings.Contains(tgt.URL, "{{") {
+		t.Error("Original Target must not be modified")
+	}
+
+	for _, bad := range []string{"http://:6060/{{.missing}}", "http://:6060/{{seq", "http://:6060/{{randint 2 1}}"} {
+		read := NewTemplateTargeter(NewStaticTargeter(&Target{Method: "GET", URL: bad}), nil)
+		if _, err := read(); err == nil || !strings.HasPrefix(err.Error(), "bad template") {
+			t.Errorf("%s: want bad template error, got %v", bad, err)
+		}
+	}
+}
+
+func TestNewTemplateTargeterBodyFile(t *testing.T) {
+	t.Parallel()
+
+	files := map[string]string{
+		"order":  `{"user": "{{.user}}", "id": {{seq}}}`,
+		"plain":  strings.Repeat("plain", 10000) + "{",
+		"binary": "\xff{{\x00",
+	}
+	for name, content := range files {
+		f, err := ioutil.TempFile("", "vegeta-")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(f.Name())
+		f.WriteString(content)
+		f.Close()
+		files[name] = f.Name()
+	}
+
+	src := strings.NewReader(fmt.Sprintf(
+		"POST http://:6060/orders\n@%s\n\nPOST http://:6060/plain\n@%s\n\nPOST http://:6060/binary\n@%s\n\nPOST http://:6060/form\nform: user={{.user}}\n",
+		files["order"], files["plain"], files["binary"],
+	))
+	var tgts []*Target
+	for tr := NewLazyTargeter(src, nil, nil); ; {
+		tgt, err := tr()
+		if err == ErrNoTargets {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		tgts = append(tgts, tgt)
+	}
+	vars := func() map[string]string { return map[string]string{"user": "goku"} }
+	read := NewTemplateTargeter(NewStaticTargeter(tgts...), vars)
+
+	for i := 0; i < 2; i++ {
+		got, err := read()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := fmt.Sprintf(`{"user": "goku", "id": %d}`, i*4); string(got.Body) != want || got.BodyFile != "" {
+			t.Errorf("want body %s rendered from the file, got %q and body file %q", want, got.Body, got.BodyFile)
+		}
+		for _, name := range []string{"plain", "binary"} {
+			if got, err = read(); err != nil {
+				t.Fatal(err)
+			} else if got.Body != nil || got.BodyFile != files[name] {
+				t.Errorf("want body file %s streamed, got %q and body file %q", files[name], got.Body, got.BodyFile)
+			}
+		}
+		if got, err = read(); err != nil {
+			t.Fatal(err)
+		} else if user := got.Form.Get("user"); user != "goku" {
+			t.Errorf("want form user goku, got %s", user)
+		}
+	}
+}
+
+func TestHasTemplates(t *testing.T) {
+	t.Parallel()
+
+	for text, want := range map[string]bool{"": false, "a{b{c": false, "a{{b": true, "{{": true} {
+		// One byte at a time, so that every "{{" is split between reads.
+		if got, err := hasTemplates(iotest.OneByteReader(strings.NewReader(text))); err != nil || got != want {
+			t.Errorf("%q: want %t, got %t (error: %v)", text, want, got, err)
+		}
+	}
+}
+
+func TestNewCSVVarSource(t *testing.T) {
+	t.Parallel()
+
+	for _, bad := range []string{"", "user,token\n", "user,token\ngoku\n"} {
+		if _, err := NewCSVVarSource(strings.NewReader(bad)); err == nil {
+			t.Errorf("%q: want error, got none", bad)
+		}
+	}
+}
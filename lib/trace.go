@@ -0,0 +1,92 @@
+package vegeta
+
+import (
+	"crypto/tls"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+// tracer records the duration of each phase of a request through
+// httptrace hooks. Hooks may fire from other goroutines, even after the
+// request is done (e.g. for dials which lost a race), hence the lock.
+type tracer struct {
+	mu sync.Mutex
+
+	dnsStart, connectStart, tlsStart time.Time
+	wrote, firstByte                 time.Time
+
+	dns, connect, tls time.Duration
+	reused            bool
+}
+
+// clientTrace returns the *httptrace.ClientTrace which feeds the tracer.
+func (t *tracer) clientTrace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) {
+			t.mu.Lock()
+			t.dnsStart = time.Now()
+			t.mu.Unlock()
+		},
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			t.mu.Lock()
+			t.dns = time.Since(t.dnsStart)
+			t.mu.Unlock()
+		},
+		ConnectStart: func(_, _ string) {
+			t.mu.Lock()
+			if t.connectStart.IsZero() {
+				t.connectStart = time.Now()
+			}
+			t.mu.Unlock()
+		},
+		ConnectDone: func(_, _ string, err error) {
+			t.mu.Lock()
+			if err == nil && t.connect == 0 {
+				t.connect = time.Since(t.connectStart)
+			}
+			t.mu.Unlock()
+		},
+		TLSHandshakeStart: func() {
+			t.mu.Lock()
+			t.tlsStart = time.Now()
+			t.mu.Unlock()
+		},
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			t.mu.Lock()
+			t.tls = time.Since(t.tlsStart)
+			t.mu.Unlock()
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			t.mu.Lock()
+			t.reused = info.Reused
+			t.mu.Unlock()
+		},
+		WroteRequest: func(httptrace.WroteRequestInfo) {
+			t.mu.Lock()
+			t.wrote = time.Now()
+			t.mu.Unlock()
+		},
+		GotFirstResponseByte: func() {
+			t.mu.Lock()
+			t.firstByte = time.Now()
+			t.mu.Unlock()
+		},
+	}
+}
+
+// record sets the traced phase durations in the given Result, given the
+// time at which the response body was read.
+func (t *tracer) record(res *Result, read time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	res.DNS, res.Connect, res.TLS = t.dns, t.connect, t.tls
+	res.Reused = t.reused
+	if !t.wrote.IsZero() && !t.firstByte.IsZero() {
+		res.FirstByte = t.firstByte.Sub(t.wrote)
+	}
+	if !t.firstByte.IsZero() && !read.IsZero() {
+		res.BodyRead = read.Sub(t.firstByte)
+	}
+}
@@ -12,6 +12,7 @@ func main() {
 	commands := map[string]command{
 		"attack": attackCmd(),
 		"report": reportCmd(),
+		"check":  checkCmd(),
 	}
 
 	flag.Usage = func() {
@@ -46,6 +47,7 @@ const examples = `
 examples:
   echo "GET http://localhost/" | vegeta attack -duration=5s | tee results.bin | vegeta report
   vegeta attack -targets=targets.txt > results.bin
+  vegeta check -targets=targets.txt
   vegeta report -inputs=results.bin -reporter=json > metrics.json
   cat results.bin | vegeta report -reporter=plot > plot.html
 `
@@ -1,42 +1,52 @@
 package main
 
 import (
+	"bytes"
 	"flag"
 	"fmt"
 	"io"
 	"os"
 	"os/signal"
 	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	vegeta "github.com/tsenart/vegeta/lib"
 )
 
 func reportCmd() command {
 	fs := flag.NewFlagSet("vegeta report", flag.ExitOnError)
-	reporter := fs.String("reporter", "text", "Reporter [text, json, plot, hist[buckets]]")
+	reporter := fs.String("reporter", "text", "Reporter [text, json, plot, dump, hist[buckets]]")
 	inputs := fs.String("inputs", "stdin", "Input files (comma separated)")
 	output := fs.String("output", "stdout", "Output file")
+	every := fs.Duration("every", 0, "Interval of reports while reading results [0 = only at the end]")
+	ps := percentiles(vegeta.DefaultPercentiles)
+	fs.Var(&ps, "percentiles", "Latency percentiles of the text and json reports (comma separated)")
+	engine := fs.String("engine", "approx", "Latency percentiles engine [approx, exact, hdr[digits]]")
 	return command{fs, func(args []string) error {
 		fs.Parse(args)
-		return report(*reporter, *inputs, *output)
+		return report(*reporter, *inputs, *output, *every, ps, *engine)
 	}}
 }
 
 // report validates the report arguments, sets up the required resources
-// and writes the report
-func report(reporter, inputs, output string) error {
+// and writes the report, along with a snapshot of it every interval
+// while reading results if every is positive
+func report(reporter, inputs, output string, every time.Duration, ps []float64, engine string) error {
 	if len(reporter) < 4 {
 		return fmt.Errorf("bad reporter: %s", reporter)
 	}
 	var rep vegeta.Reporter
 	switch reporter[:4] {
 	case "text":
-		rep = vegeta.ReportText
+		rep = vegeta.ReportMetricsText
 	case "json":
-		rep = vegeta.ReportJSON
+		rep = vegeta.ReportMetricsJSON
 	case "plot":
 		rep = vegeta.ReportPlot
+	case "dump":
+		rep = vegeta.ReportDump
 	case "hist":
 		if len(reporter) < 6 {
 			return fmt.Errorf("bad buckets: '%s'", reporter[4:])
@@ -48,6 +58,11 @@ func report(reporter, inputs, output string) error {
 		rep = hist
 	}
 
+	eng, err := vegeta.ParsePercentileEngine(engine)
+	if err != nil {
+		return err
+	}
+
 	files := strings.Split(inputs, ",")
 	srcs := make([]io.Reader, len(files))
 	for i, f := range files {
@@ -65,7 +80,23 @@ func report(reporter, inputs, output string) error {
 	}
 	defer out.Close()
 
-	var results vegeta.Results
+	// Reporters which only need Metrics have them computed as results
+	// stream in, instead of holding on to all of them.
+	mrep, streaming := rep.(vegeta.MetricsReporter)
+	var (
+		results vegeta.Results
+		m       = vegeta.NewMetrics(nil, vegeta.Percentiles(ps...), vegeta.Engine(eng))
+	)
+	var tick <-chan time.Time
+	if every > 0 {
+		if !streaming {
+			return fmt.Errorf("bad reporter for periodic reports: %s", reporter)
+		}
+		ticker := time.NewTicker(every)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
 	res, errs := vegeta.Collect(srcs...)
 	sig := make(chan os.Signal, 1)
 	signal.Notify(sig, os.Interrupt)
@@ -75,11 +106,27 @@ outer:
 		select {
 		case _ = <-sig:
 			break outer
+		case <-tick:
+			m.Close()
+			data, err := mrep(m)
+			if err != nil {
+				return err
+			}
+			if !bytes.HasSuffix(data, []byte("\n")) {
+				data = append(data, '\n')
+			}
+			if _, err = out.Write(append(data, '\n')); err != nil {
+				return err
+			}
 		case r, ok := <-res:
 			if !ok {
 				break outer
 			}
-			results = append(results, r)
+			if streaming {
+				m.Add(r)
+			} else {
+				results = append(results, r)
+			}
 		case err, ok := <-errs:
 			if !ok {
 				break outer
@@ -88,11 +135,41 @@ outer:
 		}
 	}
 
-	sort.Sort(results)
-	data, err := rep.Report(results)
+	var data []byte
+	if streaming {
+		m.Close()
+		data, err = mrep(m)
+	} else {
+		sort.Sort(results)
+		data, err = rep.Report(results)
+	}
 	if err != nil {
 		return err
 	}
 	_, err = out.Write(data)
 	return err
 }
+
+// percentiles implements the flag.Value interface for parsing a comma
+// separated list of latency percentiles
+type percentiles []float64
+
+func (ps percentiles) String() string {
+	strs := make([]string, len(ps))
+	for i, p := range ps {
+		strs[i] = strconv.FormatFloat(p, 'f', -1, 64)
+	}
+	return strings.Join(strs, ",")
+}
+
+func (ps *percentiles) Set(value string) error {
+	*ps = (*ps)[:0:0]
+	for _, v := range strings.Split(value, ",") {
+		p, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		if err != nil || p <= 0 || p > 100 {
+			return fmt.Errorf("bad percentile: %s", v)
+		}
+		*ps = append(*ps, p)
+	}
+	return nil
+}